@@ -0,0 +1,244 @@
+package compiler
+
+import (
+	"fmt"
+
+	"github.com/uiureo/jack/parser"
+)
+
+// Error is a semantic error found in a subroutine.
+type Error struct {
+	Subroutine   string
+	Line, Column int
+	Message      string
+}
+
+func (err *Error) Error() string {
+	return fmt.Sprintf("%d:%d: %s: %s", err.Line, err.Column, err.Subroutine, err.Message)
+}
+
+// Check verifies that every subroutine of the class ends in `return` on all
+// paths, that its return statements match its declaration, that break and
+// continue are used inside loops, that switch cases are unique and that
+// methods override methods of the same signature.
+func Check(node *parser.Node) []error {
+	errs := checkClass(node)
+	className := node.Children[1].Value
+	table := buildSymbolTable(node, nil)
+
+	for _, node := range node.Children {
+		if node.Name == "subroutineDec" {
+			errs = append(errs, checkSubroutineDec(node, className, table)...)
+		}
+	}
+
+	return errs
+}
+
+func checkSubroutineDec(node *parser.Node, className string, table *SymbolTable) []error {
+	errs := []error{}
+
+	subroutineType := node.Children[0].Value
+	returnType := node.Children[1].Value
+	name := className + "." + node.Children[2].Value
+
+	newError := func(at *parser.Node, message string) error {
+		line, column := at.Pos()
+		return &Error{Subroutine: name, Line: line, Column: column, Message: message}
+	}
+
+	subroutineBody, _ := node.Find(&parser.Node{Name: "subroutineBody"})
+	statements, _ := subroutineBody.Find(&parser.Node{Name: "statements"})
+
+	for _, statement := range findReturnStatements(statements) {
+		expression, _ := statement.Find(&parser.Node{Name: "expression"})
+
+		switch {
+		case subroutineType == "constructor":
+			if !isThis(expression) {
+				errs = append(errs, newError(statement, "constructor must return `this`"))
+			}
+		case returnType == "void":
+			if expression != nil {
+				errs = append(errs, newError(statement, "void subroutine must use bare `return`"))
+			}
+		default:
+			if expression == nil {
+				errs = append(errs, newError(statement, fmt.Sprintf("subroutine must return a value of type `%s`", returnType)))
+			}
+		}
+	}
+
+	for _, statement := range findJumpStatements(statements, false) {
+		errs = append(errs, newError(statement, fmt.Sprintf("`%s` outside of a loop", statement.Children[0].Value)))
+	}
+
+	for _, switchCase := range findDuplicateCases(statements, table) {
+		message := "duplicate `default` case"
+		if switchCase.Children[0].Value == "case" {
+			message = fmt.Sprintf("duplicate case `%s`", caseLabel(switchCase))
+		}
+		errs = append(errs, newError(switchCase, message))
+	}
+
+	if !returns(statements) {
+		closingBrace := subroutineBody.Children[len(subroutineBody.Children)-1]
+		errs = append(errs, newError(closingBrace, "missing return at end of subroutine"))
+	}
+
+	return errs
+}
+
+// returns reports whether every path through statements ends in `return`.
+func returns(statements *parser.Node) bool {
+	for _, statement := range statements.Children {
+		switch statement.Name {
+		case "returnStatement":
+			return true
+		case "ifStatement":
+			branches := statement.FindAll(&parser.Node{Name: "statements"})
+			if len(branches) > 1 && returns(branches[0]) && returns(branches[1]) {
+				return true
+			}
+		case "switchStatement":
+			if switchReturns(statement) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// switchReturns reports whether every case of a switch with a default case
+// ends in `return`.
+func switchReturns(statement *parser.Node) bool {
+	hasDefault := false
+
+	for _, switchCase := range statement.FindAll(&parser.Node{Name: "switchCase"}) {
+		if switchCase.Children[0].Value == "default" {
+			hasDefault = true
+		}
+
+		body, _ := switchCase.Find(&parser.Node{Name: "statements"})
+		if !returns(body) {
+			return false
+		}
+	}
+
+	return hasDefault
+}
+
+// bodies returns the statements nested in a statement: the branches of an
+// if statement, the body of a loop or the cases of a switch.
+func bodies(statement *parser.Node) []*parser.Node {
+	if statement.Name == "switchStatement" {
+		result := []*parser.Node{}
+		for _, switchCase := range statement.FindAll(&parser.Node{Name: "switchCase"}) {
+			body, _ := switchCase.Find(&parser.Node{Name: "statements"})
+			result = append(result, body)
+		}
+		return result
+	}
+
+	return statement.FindAll(&parser.Node{Name: "statements"})
+}
+
+func findReturnStatements(statements *parser.Node) []*parser.Node {
+	result := []*parser.Node{}
+
+	for _, statement := range statements.Children {
+		switch statement.Name {
+		case "returnStatement":
+			result = append(result, statement)
+		case "ifStatement", "whileStatement", "forStatement", "switchStatement":
+			for _, body := range bodies(statement) {
+				result = append(result, findReturnStatements(body)...)
+			}
+		}
+	}
+
+	return result
+}
+
+// findJumpStatements finds the break and continue statements that are not
+// inside a loop. Inside a switch, break leaves the switch.
+func findJumpStatements(statements *parser.Node, inSwitch bool) []*parser.Node {
+	result := []*parser.Node{}
+
+	for _, statement := range statements.Children {
+		switch statement.Name {
+		case "continueStatement":
+			result = append(result, statement)
+		case "breakStatement":
+			if !inSwitch {
+				result = append(result, statement)
+			}
+		case "ifStatement", "switchStatement":
+			for _, body := range bodies(statement) {
+				result = append(result, findJumpStatements(body, inSwitch || statement.Name == "switchStatement")...)
+			}
+		}
+	}
+
+	return result
+}
+
+// findDuplicateCases finds the switch cases whose value, or default, is
+// already handled by a previous case of their switch. Labels are compared by
+// value, so `case 1:` and `case Main.ONE:` are duplicates when ONE is 1.
+func findDuplicateCases(statements *parser.Node, table *SymbolTable) []*parser.Node {
+	result := []*parser.Node{}
+
+	for _, statement := range statements.Children {
+		if statement.Name == "switchStatement" {
+			seen := map[int]bool{}
+			seenDefault := false
+			for _, switchCase := range statement.FindAll(&parser.Node{Name: "switchCase"}) {
+				if switchCase.Children[0].Value == "default" {
+					if seenDefault {
+						result = append(result, switchCase)
+					}
+					seenDefault = true
+					continue
+				}
+
+				value := caseValue(switchCase, table)
+				if seen[value] {
+					result = append(result, switchCase)
+				}
+				seen[value] = true
+			}
+		}
+
+		for _, body := range bodies(statement) {
+			result = append(result, findDuplicateCases(body, table)...)
+		}
+	}
+
+	return result
+}
+
+// caseLabel returns the label of a switchCase as written, such as `-1` or
+// `Main.ONE`.
+func caseLabel(switchCase *parser.Node) string {
+	label := ""
+	for _, child := range switchCase.Children[1:] {
+		if child.Value == ":" {
+			break
+		}
+		label += child.Value
+	}
+
+	return label
+}
+
+func isThis(expression *parser.Node) bool {
+	if expression == nil || len(expression.Children) != 1 {
+		return false
+	}
+
+	term := expression.Children[0]
+
+	return len(term.Children) == 1 && term.Children[0].Name == "keyword" && term.Children[0].Value == "this"
+}
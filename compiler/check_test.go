@@ -0,0 +1,85 @@
+package compiler
+
+import (
+	"testing"
+
+	"github.com/uiureo/jack/parser"
+	"github.com/uiureo/jack/tokenizer"
+)
+
+func TestCheck(t *testing.T) {
+	errs := Check(parser.Parse(tokenizer.Tokenize(`class Foo {
+  field int x;
+
+  constructor Foo new() {
+    return x;
+  }
+
+  method void set(int a) {
+    let x = a;
+    return x;
+  }
+
+  method int get() {
+    if (x > 0) {
+      return x;
+    }
+  }
+
+  method int size() {
+    return;
+  }
+}`)))
+
+	expected := []string{
+		"5:5: Foo.new: constructor must return `this`",
+		"10:5: Foo.set: void subroutine must use bare `return`",
+		"17:3: Foo.get: missing return at end of subroutine",
+		"20:5: Foo.size: subroutine must return a value of type `int`",
+	}
+
+	if len(errs) != len(expected) {
+		t.Fatalf("expect %d errors, got %v", len(expected), errs)
+	}
+
+	for i, err := range errs {
+		if err.Error() != expected[i] {
+			t.Errorf("`%v`, want `%v`", err, expected[i])
+		}
+	}
+}
+
+func TestCheckIfElseReturns(t *testing.T) {
+	errs := Check(parser.Parse(tokenizer.Tokenize(`
+    class Foo {
+      function int sign(int a) {
+        if (a < 0) {
+          return -1;
+        } else {
+          while (a > 0) {
+            return 1;
+          }
+          return 0;
+        }
+      }
+    }`)))
+
+	if len(errs) > 0 {
+		t.Errorf("expect no errors, got %v", errs)
+	}
+}
+
+func TestCompileMissingReturn(t *testing.T) {
+	defer func() {
+		if _, ok := recover().(*Error); !ok {
+			t.Error("expect Compile to panic with *Error")
+		}
+	}()
+
+	compile(`
+    class Foo {
+      function void run() {
+        do Output.printInt(1);
+      }
+    }`)
+}
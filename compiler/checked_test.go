@@ -0,0 +1,121 @@
+package compiler
+
+import (
+	"testing"
+
+	"github.com/uiureo/jack/vm"
+)
+
+func runChecked(t *testing.T, source string) error {
+	Checked = true
+	defer func() { Checked = false }()
+
+	program, err := vm.NewProgram([]vm.File{{Name: "Main.vm", Code: compile(source)}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	machine, err := vm.New(program)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return machine.Run(10000)
+}
+
+func TestCheckedArrayAccess(t *testing.T) {
+	tests := map[string]string{
+		"let a = Array.new(3); let a[2] = 1; let x = a[2];":  "",
+		"let a = Array.new(3); let a[3] = 1;":                "index 3 is out of bounds for length 3",
+		"let a = Array.new(3); let x = a[-1];":               "index -1 is out of bounds for length 3",
+		"let a = Array.new(3); let a = a + 1; let x = a[2];": "",
+		"let x = a[0];":       "array is null",
+		"let x = a.length();": "method call on null",
+		"let a = Array.new(3); let x = Array.new(2); let x[0] = a[1 + a[2]];": "",
+	}
+
+	for statements, message := range tests {
+		err := runChecked(t, `
+class Main {
+  function void main() {
+    var Array a;
+    var int x;
+    `+statements+`
+    return;
+  }
+}`)
+
+		if message == "" && err != nil {
+			t.Errorf("%s: unexpected error: %v", statements, err)
+		}
+		if message != "" && (err == nil || err.Error() != message) {
+			t.Errorf("%s: expect error `%s`, got %v", statements, message, err)
+		}
+	}
+}
+
+func TestCheckedThis(t *testing.T) {
+	tests := map[string]string{
+		"let m = Main.new(); let x = m.get();": "",
+		"let x = Main.get(null);":              "method call on null",
+	}
+
+	for statements, message := range tests {
+		err := runChecked(t, `
+class Main {
+  field int y;
+
+  constructor Main new() {
+    let y = 2;
+    return this;
+  }
+
+  method int get() {
+    return y;
+  }
+
+  function void main() {
+    var Main m;
+    var int x;
+    `+statements+`
+    return;
+  }
+}`)
+
+		if message == "" && err != nil {
+			t.Errorf("%s: unexpected error: %v", statements, err)
+		}
+		if message != "" && (err == nil || err.Error() != message) {
+			t.Errorf("%s: expect error `%s`, got %v", statements, message, err)
+		}
+	}
+}
+
+func TestUncheckedArrayAccess(t *testing.T) {
+	code := compile(`
+class Main {
+  function void main() {
+    var Array a;
+    let a[1] = a[2];
+    return;
+  }
+}`)
+
+	compare(t, "", code, `
+function Main.main 1
+push constant 1
+push local 0
+add
+push constant 2
+push local 0
+add
+pop pointer 1
+push that 0
+pop temp 0
+pop pointer 1
+push temp 0
+pop that 0
+push constant 0
+return
+`)
+}
@@ -0,0 +1,519 @@
+package compiler
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/uiureo/jack/parser"
+)
+
+// Classes indexes the classes of the program by name, for inheritance. See
+// DeclareClasses.
+var Classes = map[string]*parser.Node{}
+
+// DeclareClasses makes the classes of the program and their constants known
+// while compiling each of them.
+func DeclareClasses(nodes []*parser.Node) {
+	Classes = map[string]*parser.Node{}
+	for _, node := range nodes {
+		Classes[node.Children[1].Value] = node
+	}
+
+	Constants = map[string]int{}
+	for _, node := range nodes {
+		for name, value := range ClassConstants(node) {
+			Constants[name] = value
+		}
+	}
+}
+
+// superclass returns the name of the class that a class extends, or "".
+func superclass(node *parser.Node) string {
+	if extends, i := node.Find(&parser.Node{Name: "keyword", Value: "extends"}); extends != nil {
+		return node.Children[i+1].Value
+	}
+
+	return ""
+}
+
+// ancestors returns the declared classes that a class inherits from, its
+// superclass first. It stops at an undeclared class or a cycle.
+func ancestors(node *parser.Node) []*parser.Node {
+	result := []*parser.Node{}
+	seen := map[string]bool{node.Children[1].Value: true}
+
+	for name := superclass(node); name != "" && Classes[name] != nil && !seen[name]; name = superclass(Classes[name]) {
+		seen[name] = true
+		result = append(result, Classes[name])
+	}
+
+	return result
+}
+
+// descendants returns the names of the classes inheriting from a class, in
+// alphabetical order.
+func descendants(className string) []string {
+	result := []string{}
+
+	for name, node := range Classes {
+		for _, ancestor := range ancestors(node) {
+			if ancestor.Children[1].Value == className {
+				result = append(result, name)
+				break
+			}
+		}
+	}
+	sort.Strings(result)
+
+	return result
+}
+
+// interfaces returns the identifiers of the interfaces that a class
+// implements.
+func interfaces(node *parser.Node) []*parser.Node {
+	implements, i := node.Find(&parser.Node{Name: "keyword", Value: "implements"})
+	if implements == nil {
+		return []*parser.Node{}
+	}
+
+	result := []*parser.Node{}
+	for _, child := range node.Children[i+1:] {
+		if child.Name == "symbol" && child.Value == "{" {
+			break
+		}
+		if child.Name == "identifier" {
+			result = append(result, child)
+		}
+	}
+
+	return result
+}
+
+// implementers returns the names of the classes implementing an interface,
+// directly or through an ancestor, in alphabetical order.
+func implementers(interfaceName string) []string {
+	result := []string{}
+
+	for name, node := range Classes {
+		if node.Name != "class" {
+			continue
+		}
+
+	classes:
+		for _, class := range append([]*parser.Node{node}, ancestors(node)...) {
+			for _, identifier := range interfaces(class) {
+				if identifier.Value == interfaceName {
+					result = append(result, name)
+					break classes
+				}
+			}
+		}
+	}
+	sort.Strings(result)
+
+	return result
+}
+
+// hasVtable reports whether the objects of a class store a pointer to the
+// vtable of their class in field 0, which is the case for classes that
+// extend, are extended or implement interfaces.
+func hasVtable(className string) bool {
+	node := Classes[className]
+
+	return node != nil && node.Name == "class" &&
+		(superclass(node) != "" || len(descendants(className)) > 0 || len(interfaces(node)) > 0)
+}
+
+// tag returns the number identifying a class at run time. The entries of
+// vtables are the tags of the classes declaring the implementations.
+func tag(className string) int {
+	names := []string{}
+	for name := range Classes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return sort.SearchStrings(names, className) + 1
+}
+
+// resolve returns the class declaring the subroutine that a class has or
+// inherits, with its declaration, or "" and nil if there is none.
+func resolve(className, name string) (string, *parser.Node) {
+	if node := Classes[className]; node != nil {
+		return resolveIn(node, name)
+	}
+
+	return "", nil
+}
+
+// resolveIn is resolve for a class node, which may not be declared yet.
+func resolveIn(node *parser.Node, name string) (string, *parser.Node) {
+	for _, class := range append([]*parser.Node{node}, ancestors(node)...) {
+		for _, child := range class.Children {
+			if child.Name == "subroutineDec" && child.Children[2].Value == name {
+				return class.Children[1].Value, child
+			}
+		}
+	}
+
+	return "", nil
+}
+
+// callee returns the VM function that a call of a subroutine of a class
+// runs: the one that the class declares or inherits, or, for a method that
+// subclasses override, a dispatch stub choosing one by the vtable of the
+// object. Constructors aren't inherited.
+func callee(className, name string) string {
+	owner, declaration := resolve(className, name)
+	if declaration == nil || (owner != className && declaration.Children[0].Value == "constructor") {
+		return className + "." + name
+	}
+
+	if declaration.Children[0].Value == "method" && len(overriders(className, name)) > 0 {
+		return className + "." + name + "$dispatch"
+	}
+
+	return owner + "." + name
+}
+
+// overriders returns the classes declaring the other implementations of a
+// method than the one of a class that its descendants run, in alphabetical
+// order.
+func overriders(className, name string) []string {
+	owner, _ := resolve(className, name)
+	result := []string{}
+	seen := map[string]bool{owner: true}
+
+	for _, descendant := range descendants(className) {
+		if implementation, _ := resolve(descendant, name); !seen[implementation] {
+			seen[implementation] = true
+			result = append(result, implementation)
+		}
+	}
+	sort.Strings(result)
+
+	return result
+}
+
+// vtableSlots returns the names of the methods that are dispatched at run
+// time, those that subclasses override and those of interfaces, in
+// alphabetical order. Every vtable has a slot for each of them, so a name has
+// the same slot in the vtables of all classes.
+func vtableSlots() []string {
+	seen := map[string]bool{}
+	for className, node := range Classes {
+		for _, child := range node.Children {
+			switch {
+			case child.Name == "subroutineSig":
+				seen[child.Children[2].Value] = true
+			case child.Name == "subroutineDec" && child.Children[0].Value == "method":
+				if len(overriders(className, child.Children[2].Value)) > 0 {
+					seen[child.Children[2].Value] = true
+				}
+			}
+		}
+	}
+
+	result := []string{}
+	for name := range seen {
+		result = append(result, name)
+	}
+	sort.Strings(result)
+
+	return result
+}
+
+// compileVtable compiles the function `Class.$vtable`, which the
+// constructors of a class call for the pointer they store in field 0. It
+// allocates the vtable of the class on its first call and keeps it in a
+// static variable. The slot of a method holds the tag of the class declaring
+// the implementation that the class runs, or 0 if it has none.
+func compileVtable(node *parser.Node, table *SymbolTable) string {
+	className := node.Children[1].Value
+	vtable := table.Get("$classVtable")
+	slots := vtableSlots()
+
+	result := fmt.Sprintf("function %s.$vtable 0\n", className)
+	result += pushSymbol(vtable)
+	result += "if-goto READY\n"
+	result += fmt.Sprintf("push constant %d\n", len(slots))
+	result += "call Memory.alloc 1\n"
+	result += popSymbol(vtable)
+	result += pushSymbol(vtable)
+	result += "pop pointer 1\n"
+
+	for i, name := range slots {
+		implementation := 0
+		if owner, declaration := resolveIn(node, name); declaration != nil && declaration.Children[0].Value == "method" {
+			implementation = tag(owner)
+		}
+
+		result += fmt.Sprintf("push constant %d\n", implementation)
+		result += fmt.Sprintf("pop that %d\n", i)
+	}
+
+	result += "label READY\n"
+	result += pushSymbol(vtable)
+	result += "return\n"
+
+	return result
+}
+
+// compileDispatchStubs compiles the dispatch stubs of the methods of a class
+// that subclasses override. A stub looks up the slot of the method in the
+// vtable of the object and calls the implementation it names.
+func compileDispatchStubs(node *parser.Node) string {
+	className := node.Children[1].Value
+	result := ""
+
+	names := []string{}
+	seen := map[string]bool{}
+	for _, class := range append([]*parser.Node{node}, ancestors(node)...) {
+		for _, child := range class.Children {
+			if child.Name == "subroutineDec" && child.Children[0].Value == "method" && !seen[child.Children[2].Value] {
+				seen[child.Children[2].Value] = true
+				names = append(names, child.Children[2].Value)
+			}
+		}
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		implementations := overriders(className, name)
+		if len(implementations) == 0 {
+			continue
+		}
+
+		owner, declaration := resolve(className, name)
+
+		result += fmt.Sprintf("function %s.%s$dispatch 0\n", className, name)
+		result += compileDispatch(name, argCount(declaration), implementations, forward(owner+"."+name, argCount(declaration)))
+	}
+
+	return result
+}
+
+// argCount returns the number of arguments of a method, including `this`.
+func argCount(node *parser.Node) int {
+	parameterList, _ := node.Find(&parser.Node{Name: "parameterList"})
+
+	// type name pairs separated by commas, and `this`
+	return (len(parameterList.Children)+1)/3 + 1
+}
+
+// forward returns code calling a function with the arguments of the current
+// one and returning its result.
+func forward(function string, argCount int) string {
+	result := ""
+	for i := 0; i < argCount; i++ {
+		result += fmt.Sprintf("push argument %d\n", i)
+	}
+
+	return result + fmt.Sprintf("call %s %d\nreturn\n", function, argCount)
+}
+
+// compileDispatch compiles the body of a function that passes its arguments
+// to the implementation of a method that the vtable of the object names, or
+// runs fallback if it names none of implementations. The VM has no indirect
+// call, so it compares the entry with each implementation, however many
+// classes run them.
+func compileDispatch(name string, argCount int, implementations []string, fallback string) string {
+	result := pushThisArgument()
+	result += "pop pointer 0\n"
+	result += "push this 0\n"
+	result += "pop pointer 1\n"
+	result += fmt.Sprintf("push that %d\n", sort.SearchStrings(vtableSlots(), name))
+	result += "pop temp 0\n"
+
+	for _, implementation := range implementations {
+		result += "push temp 0\n"
+		result += fmt.Sprintf("push constant %d\n", tag(implementation))
+		result += "eq\n"
+		result += "if-goto " + implementation + "\n"
+	}
+	result += fallback
+
+	for _, implementation := range implementations {
+		result += "label " + implementation + "\n"
+		result += forward(implementation+"."+name, argCount)
+	}
+
+	return result
+}
+
+// noImplementation is the Sys.error code of a call of an interface method on
+// an object whose class doesn't implement the interface.
+const noImplementation = 21
+
+// compileInterface compiles the methods of an interface into functions
+// dispatching on the vtable of the object to the classes implementing it.
+func compileInterface(node *parser.Node) string {
+	interfaceName := node.Children[1].Value
+	result := ""
+
+	for _, child := range node.Children {
+		if child.Name != "subroutineSig" {
+			continue
+		}
+
+		name := child.Children[2].Value
+		implementations := []string{}
+		seen := map[string]bool{}
+		for _, class := range implementers(interfaceName) {
+			if owner, declaration := resolve(class, name); declaration != nil && !seen[owner] {
+				seen[owner] = true
+				implementations = append(implementations, owner)
+			}
+		}
+		sort.Strings(implementations)
+
+		result += sourceMarker(child.Children[2])
+		result += fmt.Sprintf("function %s.%s 0\n", interfaceName, name)
+		result += compileDispatch(name, argCount(child), implementations,
+			fmt.Sprintf("push constant %d\ncall Sys.error 1\nreturn\n", noImplementation))
+	}
+
+	return result
+}
+
+// fieldNames returns the identifiers of the variables of a classVarDec.
+func fieldNames(node *parser.Node) []*parser.Node {
+	return (&parser.Node{Children: node.Children[2:]}).FindAll(&parser.Node{Name: "identifier"})
+}
+
+// signature describes a subroutine by its kind, return type and parameter
+// types, e.g. `method void (int, int)`.
+func signature(node *parser.Node) string {
+	parameterList, _ := node.Find(&parser.Node{Name: "parameterList"})
+
+	types := []string{}
+	for i := 0; i < len(parameterList.Children); i += 3 {
+		types = append(types, parameterList.Children[i].Value)
+	}
+
+	return fmt.Sprintf("%s %s (%s)", node.Children[0].Value, node.Children[1].Value, strings.Join(types, ", "))
+}
+
+// checkClass verifies that the superclass of a class is declared and not a
+// descendant, that fields aren't declared again, that methods override
+// methods of the same signature and that the class implements its
+// interfaces.
+func checkClass(node *parser.Node) []error {
+	errs := []error{}
+	className := node.Children[1].Value
+
+	newError := func(at *parser.Node, subroutine, message string) error {
+		line, column := at.Pos()
+		return &Error{Subroutine: subroutine, Line: line, Column: column, Message: message}
+	}
+
+	if node.Name == "interface" {
+		return errs
+	}
+
+	for _, identifier := range interfaces(node) {
+		switch declaration := Classes[identifier.Value]; {
+		case declaration == nil:
+			errs = append(errs, newError(identifier, className, fmt.Sprintf("interface `%s` is not defined", identifier.Value)))
+		case declaration.Name != "interface":
+			errs = append(errs, newError(identifier, className, fmt.Sprintf("`%s` is not an interface", identifier.Value)))
+		default:
+			errs = append(errs, checkImplementation(node, declaration)...)
+		}
+	}
+
+	name := superclass(node)
+	if name == "" {
+		return errs
+	}
+
+	extends, i := node.Find(&parser.Node{Name: "keyword", Value: "extends"})
+	if Classes[name] == nil {
+		return append(errs, newError(extends, className, fmt.Sprintf("class `%s` is not defined", name)))
+	}
+	if Classes[name].Name == "interface" {
+		return append(errs, newError(node.Children[i+1], className, fmt.Sprintf("`%s` is an interface, which classes implement", name)))
+	}
+
+	classAncestors := ancestors(node)
+	if last := classAncestors[len(classAncestors)-1]; superclass(last) != "" && Classes[superclass(last)] != nil {
+		return append(errs, newError(node.Children[i+1], className, fmt.Sprintf("inheritance of `%s` is cyclic", className)))
+	}
+
+	inherited := map[string]string{}
+	for _, ancestor := range classAncestors {
+		for _, child := range ancestor.Children {
+			if child.Name == "classVarDec" && child.Children[0].Value == "field" {
+				for _, field := range fieldNames(child) {
+					inherited[field.Value] = ancestor.Children[1].Value
+				}
+			}
+		}
+	}
+
+	for _, child := range node.Children {
+		switch child.Name {
+		case "classVarDec":
+			if child.Children[0].Value != "field" {
+				continue
+			}
+
+			for _, field := range fieldNames(child) {
+				if owner, ok := inherited[field.Value]; ok {
+					errs = append(errs, newError(field, className, fmt.Sprintf("field `%s` is already declared in `%s`", field.Value, owner)))
+				}
+			}
+
+		case "subroutineDec":
+			subroutineName := child.Children[2].Value
+			owner, declaration := resolve(name, subroutineName)
+			if declaration == nil || (child.Children[0].Value == "constructor" && declaration.Children[0].Value == "constructor") {
+				continue
+			}
+
+			if child.Children[0].Value == "method" || declaration.Children[0].Value == "method" {
+				if signature(child) != signature(declaration) {
+					errs = append(errs, newError(child, className+"."+subroutineName,
+						fmt.Sprintf("`%s` doesn't override `%s.%s`, which is `%s`", signature(child), owner, subroutineName, signature(declaration))))
+				}
+			}
+		}
+	}
+
+	return errs
+}
+
+// checkImplementation verifies that a class has or inherits a method of the
+// same signature for each method of an interface.
+func checkImplementation(node, declaration *parser.Node) []error {
+	errs := []error{}
+	className := node.Children[1].Value
+	interfaceName := declaration.Children[1].Value
+
+	for _, child := range declaration.Children {
+		if child.Name != "subroutineSig" {
+			continue
+		}
+
+		name := child.Children[2].Value
+		owner, implementation := resolveIn(node, name)
+		if implementation != nil && signature(implementation) == signature(child) {
+			continue
+		}
+
+		at := node.Children[1]
+		message := fmt.Sprintf("`%s` doesn't implement `%s.%s`, which is `%s`", className, interfaceName, name, signature(child))
+		if implementation != nil {
+			if owner == className {
+				at = implementation
+			}
+			message = fmt.Sprintf("`%s.%s` doesn't implement `%s.%s`, which is `%s`", owner, name, interfaceName, name, signature(child))
+		}
+
+		line, column := at.Pos()
+		errs = append(errs, &Error{Subroutine: className, Line: line, Column: column, Message: message})
+	}
+
+	return errs
+}
@@ -0,0 +1,318 @@
+package compiler
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/uiureo/jack/parser"
+	"github.com/uiureo/jack/tokenizer"
+	"github.com/uiureo/jack/vm"
+	"github.com/uiureo/jack/vm/vmtest"
+)
+
+// runClasses compiles classes written with the language extensions as a
+// program and returns what it prints on the emulator.
+func runClasses(t *testing.T, sources ...string) string {
+	t.Helper()
+
+	nodes := []*parser.Node{}
+	for _, source := range sources {
+		nodes = append(nodes, parser.ParseExt(tokenizer.TokenizeExt(source)))
+	}
+
+	DeclareClasses(nodes)
+	defer DeclareClasses(nil)
+
+	files := []vm.File{}
+	for _, node := range nodes {
+		for _, err := range Check(node) {
+			t.Fatal(err)
+		}
+		files = append(files, vm.File{Name: node.Children[1].Value + ".vm", Code: Compile(node)})
+	}
+
+	state, err := vmtest.Run(files, vmtest.Scenario{MaxSteps: 100000})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state.Err != "" {
+		t.Fatalf("runtime error: %s", state.Err)
+	}
+
+	return state.Output
+}
+
+const sprite = `
+class Sprite {
+  field int x, y;
+
+  constructor Sprite new(int ax) {
+    let x = ax;
+    let y = 1;
+    return this;
+  }
+
+  method int x() { return x; }
+
+  method void draw() {
+    do Output.printChar(83);
+    return;
+  }
+
+  method void render() {
+    do draw();
+    do Output.printInt(x + y);
+    return;
+  }
+
+  function int scale() { return 10; }
+}`
+
+func TestInheritance(t *testing.T) {
+	output := runClasses(t, sprite, `
+class Ball extends Sprite {
+  field int radius;
+
+  constructor Ball new(int ax, int r) {
+    let x = ax;
+    let y = 2;
+    let radius = r;
+    return this;
+  }
+
+  method void draw() {
+    do Output.printChar(66);
+    do Output.printInt(radius);
+    return;
+  }
+}`, `
+class Bat extends Sprite {
+  field Sprite target;
+
+  constructor Bat new() {
+    let x = 5;
+    let y = 0;
+    return this;
+  }
+}`, `
+class Main {
+  function void main() {
+    var Sprite s, b;
+    var Ball ball;
+    let s = Sprite.new(1);
+    let b = Ball.new(3, 7);
+    let ball = b;
+    do s.render();
+    do b.render();
+    do ball.draw();
+    let s = Bat.new();
+    do s.render();
+    do Output.printInt(ball.x() + Ball.scale());
+    return;
+  }
+}`)
+
+	// S2: Sprite.draw; B75: Ball.draw through Sprite.render; B7: Ball.draw
+	// called directly; S5: Bat inherits Sprite.draw; 13: inherited x() and
+	// scale()
+	if output != "S2B75B7S513" {
+		t.Errorf("got `%s`", output)
+	}
+}
+
+func TestInheritanceLayout(t *testing.T) {
+	DeclareClasses([]*parser.Node{
+		parser.ParseExt(tokenizer.TokenizeExt(sprite)),
+		parser.ParseExt(tokenizer.TokenizeExt(`class Ball extends Sprite { field int radius; }`)),
+	})
+	defer DeclareClasses(nil)
+
+	table := buildSymbolTable(Classes["Ball"], nil)
+	for name, number := range map[string]int{"$vtable": 0, "x": 1, "y": 2, "radius": 3} {
+		if symbol := table.Get(name); symbol == nil || symbol.Kind != "field" || symbol.Number != number {
+			t.Errorf("expect `%s` to be field %d, got %v", name, number, symbol)
+		}
+	}
+}
+
+func TestInheritanceErrors(t *testing.T) {
+	DeclareClasses([]*parser.Node{
+		parser.ParseExt(tokenizer.TokenizeExt(sprite)),
+		parser.ParseExt(tokenizer.TokenizeExt(`class A extends B { }`)),
+		parser.ParseExt(tokenizer.TokenizeExt(`class B extends A { }`)),
+	})
+	defer DeclareClasses(nil)
+
+	tests := map[string]string{
+		"class Ball extends Shape { }":                                     "1:12: Ball: class `Shape` is not defined",
+		"class C extends A { }":                                            "1:17: C: inheritance of `C` is cyclic",
+		"class Ball extends Sprite { field int y; }":                       "1:39: Ball: field `y` is already declared in `Sprite`",
+		"class Ball extends Sprite { method int draw() { return 0; } }":    "1:29: Ball.draw: `method int ()` doesn't override `Sprite.draw`, which is `method void ()`",
+		"class Ball extends Sprite { function void render() { return; } }": "1:29: Ball.render: `function void ()` doesn't override `Sprite.render`, which is `method void ()`",
+		"class Ball extends Sprite { function int scale() { return 2; } }": "",
+	}
+
+	for source, message := range tests {
+		errs := Check(parser.ParseExt(tokenizer.TokenizeExt(source)))
+
+		if message == "" && len(errs) > 0 {
+			t.Errorf("%s: unexpected errors: %v", source, errs)
+		}
+		if message != "" && (len(errs) != 1 || errs[0].Error() != message) {
+			t.Errorf("%s: expect error `%s`, got %v", source, message, errs)
+		}
+	}
+}
+
+// TestDispatchCost checks that a call of an overridden method costs the same
+// however many classes inherit the override, as the stub looks the
+// implementation up in the vtable of the object.
+func TestDispatchCost(t *testing.T) {
+	// steps returns the number of steps of a program in which n classes
+	// inherit Ball.draw and main calls draw on one of them calls times.
+	steps := func(n, calls int) int {
+		sources := []string{sprite, `
+class Ball extends Sprite {
+  method void draw() { return; }
+}`}
+		for i := 1; i <= n; i++ {
+			sources = append(sources, fmt.Sprintf("class Beach%d extends Ball { constructor Beach%d new() { return this; } }", i, i))
+		}
+		sources = append(sources, fmt.Sprintf(`
+class Main {
+  function void main() {
+    var Sprite s;
+    let s = Beach%d.new();
+    %s
+    return;
+  }
+}`, n, strings.Repeat("do s.draw(); ", calls)))
+
+		nodes := []*parser.Node{}
+		for _, source := range sources {
+			nodes = append(nodes, parser.ParseExt(tokenizer.TokenizeExt(source)))
+		}
+		DeclareClasses(nodes)
+		defer DeclareClasses(nil)
+
+		files := []vm.File{}
+		for _, node := range nodes {
+			files = append(files, vm.File{Name: node.Children[1].Value + ".vm", Code: Compile(node)})
+		}
+
+		program, err := vm.NewProgram(files)
+		if err != nil {
+			t.Fatal(err)
+		}
+		machine, err := vm.New(program)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := machine.Run(100000); err != nil {
+			t.Fatal(err)
+		}
+
+		return machine.Steps
+	}
+
+	one := steps(1, 2) - steps(1, 1)
+	many := steps(8, 2) - steps(8, 1)
+	if one != many {
+		t.Errorf("a call costs %d steps with 1 class inheriting the override, but %d with 8", one, many)
+	}
+}
+
+const drawable = `
+interface Drawable {
+  method void draw();
+  method int area(int scale);
+}`
+
+func TestInterfaces(t *testing.T) {
+	output := runClasses(t, sprite, drawable, `
+class Ball extends Sprite implements Drawable {
+  constructor Ball new(int ax) {
+    let x = ax;
+    return this;
+  }
+
+  method void draw() {
+    do Output.printChar(66);
+    return;
+  }
+
+  method int area(int scale) { return x * scale; }
+}`, `
+class Box implements Drawable {
+  field int side;
+
+  constructor Box new(int s) {
+    let side = s;
+    return this;
+  }
+
+  method void draw() {
+    do Output.printChar(88);
+    return;
+  }
+
+  method int area(int scale) { return side * side * scale; }
+}`, `
+class Beach extends Ball {
+  constructor Beach new() {
+    let x = 4;
+    return this;
+  }
+
+  method int area(int scale) { return 1; }
+}`, `
+class Main {
+  function void main() {
+    var Drawable d;
+    let d = Ball.new(3);
+    do d.draw();
+    do Output.printInt(d.area(2));
+    let d = Box.new(3);
+    do d.draw();
+    do Output.printInt(d.area(2));
+    let d = Beach.new();
+    do d.draw();
+    do Output.printInt(d.area(2));
+    return;
+  }
+}`)
+
+	// B6: Ball; X18: Box; B1: Beach inherits Ball.draw and overrides area
+	if output != "B6X18B1" {
+		t.Errorf("got `%s`", output)
+	}
+}
+
+func TestInterfaceErrors(t *testing.T) {
+	DeclareClasses([]*parser.Node{
+		parser.ParseExt(tokenizer.TokenizeExt(sprite)),
+		parser.ParseExt(tokenizer.TokenizeExt(drawable)),
+	})
+	defer DeclareClasses(nil)
+
+	tests := map[string]string{
+		"class Ball implements Shape { }":                                                        "1:23: Ball: interface `Shape` is not defined",
+		"class Ball implements Sprite { }":                                                       "1:23: Ball: `Sprite` is not an interface",
+		"class Ball extends Drawable { }":                                                        "1:20: Ball: `Drawable` is an interface, which classes implement",
+		"class Ball extends Sprite implements Drawable { }":                                      "1:7: Ball: `Ball` doesn't implement `Drawable.area`, which is `method int (int)`",
+		"class Ball extends Sprite implements Drawable { method int area() { return 0; } }":      "1:49: Ball: `Ball.area` doesn't implement `Drawable.area`, which is `method int (int)`",
+		"class Ball extends Sprite implements Drawable { method int area(int s) { return s; } }": "",
+	}
+
+	for source, message := range tests {
+		errs := Check(parser.ParseExt(tokenizer.TokenizeExt(source)))
+
+		if message == "" && len(errs) > 0 {
+			t.Errorf("%s: unexpected errors: %v", source, errs)
+		}
+		if message != "" && (len(errs) != 1 || errs[0].Error() != message) {
+			t.Errorf("%s: expect error `%s`, got %v", source, message, errs)
+		}
+	}
+}
@@ -8,17 +8,49 @@ import (
 )
 
 func Compile(node *parser.Node) string {
-	result := ""
-	table := buildSymbolTable(node, nil)
+	code, _ := CompileWithSourceMap(node, "", false)
+
+	return code
+}
 
+// compileClass compiles a class into VM code interleaved with source
+// markers, see sourceMarker.
+func compileClass(node *parser.Node) string {
 	className := node.Children[1].Value
 
+	// a class compiled on its own knows itself
+	if Classes[className] == nil {
+		Classes[className] = node
+		defer delete(Classes, className)
+	}
+
+	if errs := checkClass(node); len(errs) > 0 {
+		panic(errs[0])
+	}
+
+	if node.Name == "interface" {
+		return compileInterface(node)
+	}
+
+	result := ""
+	table := buildSymbolTable(node, nil)
+
 	for _, node := range node.Children {
 		if node.Name == "subroutineDec" {
 			result += compileSubroutineDec(node, table, className)
 		}
 	}
 
+	if hasVtable(className) {
+		result += sourceMarker(node.Children[1])
+		result += compileVtable(node, table)
+	}
+
+	if stubs := compileDispatchStubs(node); stubs != "" {
+		result += sourceMarker(node.Children[1])
+		result += stubs
+	}
+
 	return result
 }
 
@@ -40,8 +72,49 @@ func popSymbol(symbol *Symbol) string {
 
 var labelCount = map[string]int{}
 
+// loop holds the jump targets of an enclosing loop, or of a switch, which
+// break leaves and continue sees through.
+type loop struct {
+	continueLabel, breakLabel string
+}
+
+// loops are the loops and switches enclosing the statement being compiled,
+// innermost last.
+var loops = []loop{}
+
+// Checked makes the compiler emit runtime checks, which fail on array
+// accesses and method calls through null pointers, on methods running on a
+// null `this`, and on indexes out of the bounds of arrays allocated by
+// Array.new. The checks call the Runtime class of the VM emulator.
+var Checked = false
+
+// pushThisArgument pushes the object a method is called on, which must not
+// be null in checked mode.
+func pushThisArgument() string {
+	if Checked {
+		return "push argument 0\ncall Runtime.checkObject 1\n"
+	}
+
+	return "push argument 0\n"
+}
+
+// pushElementAddress adds the base address of an array to the index on the
+// stack.
+func pushElementAddress(array *Symbol) string {
+	if Checked {
+		return pushSymbol(array) + "call Runtime.checkIndex 2\n"
+	}
+
+	return pushSymbol(array) + "add\n"
+}
+
 func compileSubroutineDec(node *parser.Node, classTable *SymbolTable, className string) string {
+	if errs := checkSubroutineDec(node, className, classTable); len(errs) > 0 {
+		panic(errs[0])
+	}
+
 	labelCount = map[string]int{}
+	loops = []loop{}
 	result := ""
 
 	table := buildSymbolTable(node, classTable)
@@ -54,6 +127,7 @@ func compileSubroutineDec(node *parser.Node, classTable *SymbolTable, className
 		}
 	}
 
+	result += sourceMarker(node.Children[2])
 	result += fmt.Sprintf("function %s.%s %d\n", className, name, localVarCount)
 
 	subroutineType := node.Children[0].Value
@@ -69,8 +143,13 @@ func compileSubroutineDec(node *parser.Node, classTable *SymbolTable, className
 		result += fmt.Sprintf("push constant %d\n", fieldCount)
 		result += "call Memory.alloc 1\n"
 		result += "pop pointer 0\n"
+
+		if hasVtable(className) {
+			result += fmt.Sprintf("call %s.$vtable 0\n", className)
+			result += "pop this 0\n"
+		}
 	case "method":
-		result += "push argument 0\n"
+		result += pushThisArgument()
 		result += "pop pointer 0\n"
 	}
 
@@ -93,6 +172,8 @@ func pushStatements(statements *parser.Node, table *SymbolTable) string {
 	result := ""
 
 	for _, statement := range statements.Children {
+		result += sourceMarker(statement)
+
 		switch statement.Name {
 		case "letStatement":
 			identifier, _ := statement.Find(&parser.Node{Name: "identifier"})
@@ -100,14 +181,29 @@ func pushStatements(statements *parser.Node, table *SymbolTable) string {
 			if symbol == nil {
 				panic(fmt.Sprintf("variable `%v` is not defined: %v\n%v", identifier.Value, table.String(), statements.ToXML()))
 			}
+			if symbol.Kind == "const" {
+				panic(fmt.Sprintf("cannot assign to constant `%s`", identifier.Value))
+			}
 
 			bracket, _ := statement.Find(&parser.Node{Name: "symbol", Value: "["})
 			if bracket != nil {
 				expressions := statement.FindAll(&parser.Node{Name: "expression"})
 				result += pushExpression(expressions[0], table)
-				result += pushSymbol(symbol)
-				result += "add\n"
-				result += pushExpression(expressions[1], table)
+				result += pushElementAddress(symbol)
+
+				if value := expressions[1]; isCompoundAssignment(expressions[0], value) {
+					// keep the address for the store and read the element through it
+					result += "pop temp 0\n"
+					result += "push temp 0\n"
+					result += "push temp 0\n"
+					result += "pop pointer 1\n"
+					result += "push that 0\n"
+					result += compileTerm(value.Children[2], table)
+					result += compileOperator(value.Children[1].Value)
+				} else {
+					result += pushExpression(value, table)
+				}
+
 				result += "pop temp 0\n"
 				result += "pop pointer 1\n"
 				result += "push temp 0\n"
@@ -176,15 +272,135 @@ func pushStatements(statements *parser.Node, table *SymbolTable) string {
 			result += "if-goto " + endLabel + "\n"
 
 			whileBody, _ := statement.Find(&parser.Node{Name: "statements"})
-			result += pushStatements(whileBody, table)
+			result += pushLoopBody(whileBody, table, loop{expLabel, endLabel})
+			result += "goto " + expLabel + "\n"
+			result += "label " + endLabel + "\n"
+
+		case "forStatement":
+			lets := statement.FindAll(&parser.Node{Name: "letStatement"})
+			initialization, update := lets[0], lets[1]
+
+			expLabel := uniqueLabel("FOR_EXP")
+			updateLabel := uniqueLabel("FOR_UPDATE")
+			endLabel := uniqueLabel("FOR_END")
+
+			result += pushStatements(&parser.Node{Name: "statements", Children: []*parser.Node{initialization}}, table)
+			result += "label " + expLabel + "\n"
+			forExpression, _ := statement.Find(&parser.Node{Name: "expression"})
+			result += pushExpression(forExpression, table)
+			result += "not\n"
+			result += "if-goto " + endLabel + "\n"
+
+			forBody, _ := statement.Find(&parser.Node{Name: "statements"})
+			result += pushLoopBody(forBody, table, loop{updateLabel, endLabel})
+			result += "label " + updateLabel + "\n"
+			result += pushStatements(&parser.Node{Name: "statements", Children: []*parser.Node{update}}, table)
 			result += "goto " + expLabel + "\n"
 			result += "label " + endLabel + "\n"
+
+		case "switchStatement":
+			result += pushSwitchStatement(statement, table)
+
+		case "breakStatement", "continueStatement":
+			label := ""
+			if len(loops) > 0 {
+				innermost := loops[len(loops)-1]
+				if statement.Name == "breakStatement" {
+					label = innermost.breakLabel
+				} else {
+					label = innermost.continueLabel
+				}
+			}
+			if label == "" {
+				panic(fmt.Sprintf("`%s` outside of a loop", statement.Children[0].Value))
+			}
+
+			result += "goto " + label + "\n"
 		}
 	}
 
 	return result
 }
 
+// isCompoundAssignment reports whether the value assigned to an array
+// element is the element op term, as the parser makes `a[i] += e`, whose
+// index is evaluated once.
+func isCompoundAssignment(index, value *parser.Node) bool {
+	target := value.Children[0]
+
+	return len(value.Children) == 3 && len(target.Children) == 4 && target.Children[2] == index
+}
+
+// pushLoopBody compiles the body of a loop or a switch case, where break
+// and continue jump to the labels of l.
+func pushLoopBody(body *parser.Node, table *SymbolTable, l loop) string {
+	loops = append(loops, l)
+	result := pushStatements(body, table)
+	loops = loops[:len(loops)-1]
+
+	return result
+}
+
+// pushSwitchStatement compiles a switch to a chain of comparisons with the
+// value of its expression, kept in temp 1 meanwhile, as the VM has no
+// indirect jump for a jump table. Cases don't fall through.
+func pushSwitchStatement(statement *parser.Node, table *SymbolTable) string {
+	endLabel := uniqueLabel("SWITCH_END")
+	defaultLabel := endLabel
+
+	expression, _ := statement.Find(&parser.Node{Name: "expression"})
+	result := pushExpression(expression, table)
+	result += "pop temp 1\n"
+
+	switchCases := statement.FindAll(&parser.Node{Name: "switchCase"})
+	labels := make([]string, len(switchCases))
+	for i, switchCase := range switchCases {
+		labels[i] = uniqueLabel("SWITCH_CASE")
+
+		if switchCase.Children[0].Value == "default" {
+			defaultLabel = labels[i]
+			continue
+		}
+
+		result += "push temp 1\n"
+		result += pushConstant(caseValue(switchCase, table))
+		result += "eq\n"
+		result += "if-goto " + labels[i] + "\n"
+	}
+	result += "goto " + defaultLabel + "\n"
+
+	continueLabel := ""
+	if len(loops) > 0 {
+		continueLabel = loops[len(loops)-1].continueLabel
+	}
+
+	for i, switchCase := range switchCases {
+		body, _ := switchCase.Find(&parser.Node{Name: "statements"})
+
+		result += "label " + labels[i] + "\n"
+		result += pushLoopBody(body, table, loop{continueLabel, endLabel})
+		result += "goto " + endLabel + "\n"
+	}
+	result += "label " + endLabel + "\n"
+
+	return result
+}
+
+// caseValue returns the value of the label of a switchCase: an integer
+// constant, possibly negative, or a constant `Class.NAME`.
+func caseValue(switchCase *parser.Node, table *SymbolTable) int {
+	label := switchCase.Children[1]
+
+	switch {
+	case label.Name == "identifier":
+		return qualifiedConstant(label.Value, switchCase.Children[3].Value, table)
+	case label.Value == "-":
+		return -integerValue(switchCase.Children[2].Value)
+	default:
+		return integerValue(label.Value)
+	}
+}
+
 func pushExpression(expression *parser.Node, table *SymbolTable) string {
 	if expression == nil {
 		panic("argument must not be nil")
@@ -194,20 +410,114 @@ func pushExpression(expression *parser.Node, table *SymbolTable) string {
 		panic(fmt.Sprintf("argument must be `expression`, but actual: %v", expression.ToXML()))
 	}
 
-	leftTerm, _ := expression.Find(&parser.Node{Name: "term"})
+	if isConditional(expression) {
+		return pushConditional(expression, table)
+	}
 
-	result := compileTerm(leftTerm, table)
+	// Jack operators have no precedence: term (op term)* is evaluated
+	// from left to right.
+	result := compileTerm(expression.Children[0], table)
 
-	if len(expression.Children) > 1 {
-		operator := expression.Children[1]
-		expression.Children = expression.Children[2:]
-		result += pushExpression(expression, table)
+	for i := 1; i+1 < len(expression.Children); i += 2 {
+		operator, term := expression.Children[i], expression.Children[i+1]
+
+		if operator.Value == "&&" || operator.Value == "||" {
+			result += compileShortCircuit(operator.Value, compileTerm(term, table))
+			continue
+		}
+
+		if amount, ok := constantShift(operator.Value, term); ok {
+			result += compileShiftLeft(amount)
+			continue
+		}
+
+		result += compileTerm(term, table)
 		result += compileOperator(operator.Value)
 	}
 
 	return result
 }
 
+// constantShift returns the amount of `<< n` for an integer constant n that
+// compileShiftLeft inlines.
+func constantShift(operator string, term *parser.Node) (int, bool) {
+	if operator != "<<" || len(term.Children) != 1 || term.Children[0].Name != "integerConstant" {
+		return 0, false
+	}
+
+	amount, err := strconv.Atoi(term.Children[0].Value)
+
+	return amount, err == nil && amount < 16
+}
+
+// compileShiftLeft doubles the value on the stack amount times, by adding it
+// to itself.
+func compileShiftLeft(amount int) string {
+	result := ""
+	for i := 0; i < amount; i++ {
+		result += "pop temp 0\n"
+		result += "push temp 0\n"
+		result += "push temp 0\n"
+		result += "add\n"
+	}
+
+	return result
+}
+
+// isConditional reports whether an expression is `condition ? a : b`.
+func isConditional(expression *parser.Node) bool {
+	return len(expression.Children) == 5 && expression.Children[1].Value == "?"
+}
+
+// pushConditional compiles `condition ? a : b`, which evaluates either a or
+// b.
+func pushConditional(expression *parser.Node, table *SymbolTable) string {
+	trueLabel := uniqueLabel("COND_TRUE")
+	endLabel := uniqueLabel("COND_END")
+
+	result := pushExpression(expression.Children[0], table)
+	result += "if-goto " + trueLabel + "\n"
+	result += pushExpression(expression.Children[4], table)
+	result += "goto " + endLabel + "\n"
+	result += "label " + trueLabel + "\n"
+	result += pushExpression(expression.Children[2], table)
+	result += "label " + endLabel + "\n"
+
+	return result
+}
+
+// compileShortCircuit compiles `&&` and `||`, which evaluate their right
+// operand only if the left one on the stack doesn't decide the result. The
+// result is true or false.
+func compileShortCircuit(operator, right string) string {
+	result := ""
+	right += "push constant 0\neq\nnot\n"
+
+	if operator == "&&" {
+		rightLabel := uniqueLabel("AND_RIGHT")
+		endLabel := uniqueLabel("AND_END")
+
+		result += "if-goto " + rightLabel + "\n"
+		result += "push constant 0\n"
+		result += "goto " + endLabel + "\n"
+		result += "label " + rightLabel + "\n"
+		result += right
+		result += "label " + endLabel + "\n"
+	} else {
+		trueLabel := uniqueLabel("OR_TRUE")
+		endLabel := uniqueLabel("OR_END")
+
+		result += "if-goto " + trueLabel + "\n"
+		result += right
+		result += "goto " + endLabel + "\n"
+		result += "label " + trueLabel + "\n"
+		result += "push constant 0\nnot\n"
+		result += "label " + endLabel + "\n"
+	}
+
+	return result
+}
+
 func compileOperator(operator string) string {
 	switch operator {
 	case "+":
@@ -228,6 +538,20 @@ func compileOperator(operator string) string {
 		return "or\n"
 	case "=":
 		return "eq\n"
+	case "!=":
+		return "eq\nnot\n"
+	case "<=":
+		return "gt\nnot\n"
+	case ">=":
+		return "lt\nnot\n"
+	case "%":
+		return "call Runtime.mod 2\n"
+	case "<<":
+		return "call Runtime.shiftLeft 2\n"
+	case ">>":
+		return "call Runtime.shiftRight 2\n"
+	case "^":
+		return "call Runtime.xor 2\n"
 	default:
 		return ""
 	}
@@ -257,6 +581,8 @@ func compileTerm(term *parser.Node, table *SymbolTable) string {
 
 	switch firstChild.Name {
 	case "integerConstant":
+		integerValue(firstChild.Value)
+
 		return fmt.Sprintf("push constant %s\n", firstChild.Value)
 	case "stringConstant":
 		return pushString(firstChild.Value)
@@ -270,16 +596,29 @@ func compileTerm(term *parser.Node, table *SymbolTable) string {
 			return "push pointer 0\n"
 		}
 	case "identifier":
+		if dot, _ := term.Find(&parser.Node{Name: "symbol", Value: "."}); dot != nil {
+			return pushConstant(qualifiedConstant(firstChild.Value, term.Children[2].Value, table))
+		}
+
 		symbol := table.Get(firstChild.Value)
+		if symbol == nil {
+			panic(fmt.Sprintf("variable `%v` is not defined", firstChild.Value))
+		}
 
 		bracket, _ := term.Find(&parser.Node{Name: "symbol", Value: "["})
+		if symbol.Kind == "const" {
+			if bracket != nil {
+				panic(fmt.Sprintf("constant `%s` is not an array", firstChild.Value))
+			}
+			return pushConstant(symbol.Number)
+		}
+
 		if bracket != nil {
 			result := ""
 
 			expression, _ := term.Find(&parser.Node{Name: "expression"})
 			result += pushExpression(expression, table)
-			result += pushSymbol(symbol)
-			result += "add\n"
+			result += pushElementAddress(symbol)
 			result += "pop pointer 1\n"
 			result += "push that 0\n"
 
@@ -302,14 +641,61 @@ func compileTerm(term *parser.Node, table *SymbolTable) string {
 	return ""
 }
 
+// pushConstant pushes a value, which may be negative.
+func pushConstant(value int) string {
+	if value < 0 {
+		return fmt.Sprintf("push constant %d\nneg\n", -value)
+	}
+
+	return fmt.Sprintf("push constant %d\n", value)
+}
+
+// Constants holds the constants of the classes of the program, such as
+// `Direction.UP`, for the terms `Class.CONST` of other classes. See
+// ClassConstants.
+var Constants = map[string]int{}
+
+// ClassConstants returns the constants declared by a class, by qualified
+// name such as `Direction.UP`.
+func ClassConstants(node *parser.Node) map[string]int {
+	constants := map[string]int{}
+	className := node.Children[1].Value
+
+	for name, symbol := range buildSymbolTable(node, nil).Scopes[0] {
+		if symbol.Kind == "const" {
+			constants[className+"."+name] = symbol.Number
+		}
+	}
+
+	return constants
+}
+
+// qualifiedConstant returns the value of `className.name`, a constant of
+// the class being compiled or of Constants.
+func qualifiedConstant(className, name string, table *SymbolTable) int {
+	if className == table.Find(&Symbol{Kind: "class"}).SymbolType {
+		if symbol := table.Get(name); symbol != nil && symbol.Kind == "const" {
+			return symbol.Number
+		}
+	} else if value, ok := Constants[className+"."+name]; ok {
+		return value
+	}
+
+	panic(fmt.Sprintf("constant `%s.%s` is not defined", className, name))
+}
+
 func pushString(str string) string {
 	result := ""
 
-	size := len(str)
+	size := len([]rune(str))
 	result += fmt.Sprintf("push constant %d\n", size)
 	result += "call String.new 1\n"
 
 	for _, ch := range str {
+		if ch > 32767 {
+			panic(fmt.Sprintf("character %q in string constant is out of range", ch))
+		}
+
 		result += fmt.Sprintf("push constant %d\n", rune(ch))
 		result += "call String.appendChar 2\n"
 	}
@@ -327,7 +713,7 @@ func compileSubroutineCall(node *parser.Node, table *SymbolTable) string {
 		subroutineName := node.Children[0].Value
 		thisClassName := table.Find(&Symbol{Kind: "class"}).SymbolType
 
-		functionName = fmt.Sprintf("%s.%s", thisClassName, subroutineName)
+		functionName = callee(thisClassName, subroutineName)
 
 		result += "push pointer 0\n"
 		argSize++
@@ -335,18 +721,23 @@ func compileSubroutineCall(node *parser.Node, table *SymbolTable) string {
 		classOrVarName := node.Children[0].Value
 
 		var className string
-		if symbol := table.Get(classOrVarName); symbol != nil && symbol.Kind != "class" {
+		if symbol := table.Get(classOrVarName); symbol != nil && symbol.Kind == "const" {
+			panic(fmt.Sprintf("constant `%s` has no methods", classOrVarName))
+		} else if symbol != nil && symbol.Kind != "class" {
 			className = symbol.SymbolType
 			argSize++
 
 			result += pushSymbol(symbol)
+			if Checked {
+				result += "call Runtime.checkObject 1\n"
+			}
 		} else {
 			className = classOrVarName
 		}
 
 		subroutineName := node.Children[2].Value
 
-		functionName = fmt.Sprintf("%s.%s", className, subroutineName)
+		functionName = callee(className, subroutineName)
 	}
 
 	expressionList, _ := node.Find(&parser.Node{Name: "expressionList"})
@@ -363,6 +754,52 @@ func compileSubroutineCall(node *parser.Node, table *SymbolTable) string {
 	return result
 }
 
+// SymbolTables returns the symbol table of each subroutine of a class, keyed
+// by VM function name such as `Main.main`. The class scope is the last scope
+// of each table.
+func SymbolTables(node *parser.Node) map[string]*SymbolTable {
+	tables := map[string]*SymbolTable{}
+	classTable := buildSymbolTable(node, nil)
+	className := node.Children[1].Value
+
+	for _, node := range node.Children {
+		if node.Name == "subroutineDec" {
+			tables[className+"."+node.Children[2].Value] = buildSymbolTable(node, classTable)
+		}
+	}
+
+	return tables
+}
+
+// constValue returns the value of a constDec.
+func constValue(node *parser.Node) int {
+	value := node.Children[len(node.Children)-2]
+
+	switch value.Value {
+	case "true":
+		return -1
+	case "false":
+		return 0
+	}
+
+	n := integerValue(value.Value)
+	if node.Children[len(node.Children)-3].Value == "-" {
+		n = -n
+	}
+
+	return n
+}
+
+// integerValue returns the value of an integerConstant.
+func integerValue(value string) int {
+	n, err := strconv.Atoi(value)
+	if err != nil || n > 32767 {
+		panic(fmt.Sprintf("integer constant `%s` is out of range", value))
+	}
+
+	return n
+}
+
 func buildSymbolTable(node *parser.Node, base *SymbolTable) *SymbolTable {
 	if base == nil {
 		base = &SymbolTable{}
@@ -384,6 +821,22 @@ func buildSymbolTable(node *parser.Node, base *SymbolTable) *SymbolTable {
 
 		table.Set(className, &Symbol{Kind: "class", SymbolType: className})
 
+		// the vtable pointer and the inherited fields come first
+		if hasVtable(className) {
+			table.Set("$vtable", &Symbol{SymbolType: "int", Kind: "field"})
+		}
+
+		classAncestors := ancestors(node)
+		for i := len(classAncestors) - 1; i >= 0; i-- {
+			for _, node := range classAncestors[i].Children {
+				if node.Name == "classVarDec" && node.Children[0].Value == "field" {
+					for _, field := range fieldNames(node) {
+						table.Set(field.Value, &Symbol{SymbolType: node.Children[1].Value, Kind: "field"})
+					}
+				}
+			}
+		}
+
 		for _, node := range node.Children {
 			if node.Name == "classVarDec" {
 				kind := node.Children[0].Value
@@ -400,6 +853,22 @@ func buildSymbolTable(node *parser.Node, base *SymbolTable) *SymbolTable {
 					table.Set(name, &Symbol{SymbolType: symbolType, Kind: kind})
 				}
 			}
+
+			if node.Name == "constDec" {
+				table.Set(node.Children[2].Value, &Symbol{SymbolType: node.Children[1].Value, Kind: "const", Number: constValue(node)})
+			}
+
+			if node.Name == "enumDec" {
+				enumName := node.Children[1].Value
+				for i, member := range node.FindAll(&parser.Node{Name: "identifier"})[1:] {
+					table.Set(member.Value, &Symbol{SymbolType: enumName, Kind: "const", Number: i})
+				}
+			}
+		}
+
+		// after the static variables, so that their numbers don't change
+		if hasVtable(className) {
+			table.Set("$classVtable", &Symbol{SymbolType: "int", Kind: "static"})
 		}
 	case "subroutineDec":
 		if node.Children[0].Value == "method" {
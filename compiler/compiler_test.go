@@ -9,6 +9,8 @@ import (
 
 	"github.com/uiureo/jack/parser"
 	"github.com/uiureo/jack/tokenizer"
+	"github.com/uiureo/jack/vm"
+	"github.com/uiureo/jack/vm/vmtest"
 )
 
 func TestBuildSymbolTableFromClass(t *testing.T) {
@@ -166,26 +168,41 @@ func TestCompileSeven(t *testing.T) {
 }
 
 func TestCompileConvertToBin(t *testing.T) {
-	testCompileFiles(t, "./fixtures/ConvertToBin/*.jack")
+	testCompileFiles(t, "./fixtures/ConvertToBin/*.jack",
+		vmtest.Scenario{Name: "zero", RAM: map[int]int16{8000: 0}},
+		vmtest.Scenario{Name: "positive", RAM: map[int]int16{8000: 1234}},
+		vmtest.Scenario{Name: "negative", RAM: map[int]int16{8000: -1}},
+	)
 }
 
 func TestCompileSquare(t *testing.T) {
-	testCompileFiles(t, "./fixtures/Square/*.jack")
+	testCompileFiles(t, "./fixtures/Square/*.jack",
+		vmtest.Scenario{Name: "idle"},
+		vmtest.Scenario{Name: "move and resize", Keyboard: []int16{132, 132, 0, 0, 0, 133, 0, 0, 90, 0, 88, 88, 0, 130, 0, 131, 0, 0}},
+		vmtest.Scenario{Name: "quit", Keyboard: []int16{133, 0, 0, 81}},
+	)
 }
 
 func TestCompileAverage(t *testing.T) {
-	testCompileFiles(t, "./fixtures/Average/*.jack")
+	testCompileFiles(t, "./fixtures/Average/*.jack",
+		vmtest.Scenario{Name: "three numbers", Keyboard: vm.KeyboardInput("3\n10\n20\n33\n")},
+		vmtest.Scenario{Name: "no input", Keyboard: nil},
+	)
 }
 
 func TestCompilePong(t *testing.T) {
-	testCompileFiles(t, "./fixtures/Pong/*.jack")
+	testCompileFiles(t, "./fixtures/Pong/*.jack",
+		vmtest.Scenario{Name: "play", Keyboard: append(make([]int16, 200), 130, 130, 0, 0, 0, 132, 0, 0, 0, 0, 140)},
+	)
 }
 
 func TestCompileComplexArrays(t *testing.T) {
 	testCompileFiles(t, "./fixtures/ComplexArrays/*.jack")
 }
 
-func testCompileFiles(t *testing.T, pattern string) {
+// testCompileFiles compiles the Jack files matched by pattern and checks that
+// the result behaves like the reference .vm files next to them.
+func testCompileFiles(t *testing.T, pattern string, scenarios ...vmtest.Scenario) {
 	jackFiles, _ := filepath.Glob(pattern)
 
 	if len(jackFiles) == 0 {
@@ -193,16 +210,19 @@ func testCompileFiles(t *testing.T, pattern string) {
 		return
 	}
 
+	var compiled, expected []vm.File
 	for _, jackFile := range jackFiles {
 		name := strings.Split(filepath.Base(jackFile), ".")[0]
 		vmFile := filepath.Dir(jackFile) + "/" + name + ".vm"
 		vmData, _ := ioutil.ReadFile(vmFile)
 
 		jackData, _ := ioutil.ReadFile(jackFile)
-		compiled := compile(string(jackData))
 
-		compare(t, name, compiled, string(vmData))
+		compiled = append(compiled, vm.File{Name: name + ".vm", Code: compile(string(jackData))})
+		expected = append(expected, vm.File{Name: name + ".vm", Code: string(vmData)})
 	}
+
+	vmtest.Compare(t, compiled, expected, scenarios...)
 }
 
 func compile(source string) string {
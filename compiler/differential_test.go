@@ -0,0 +1,388 @@
+package compiler
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"strings"
+	"testing"
+
+	"github.com/uiureo/jack/parser"
+	"github.com/uiureo/jack/tokenizer"
+	"github.com/uiureo/jack/vm"
+	"github.com/uiureo/jack/vm/vmtest"
+)
+
+// The differential test compiles random programs and checks that they
+// compute on the emulator what a reference evaluator computes from the same
+// syntax tree.
+
+var (
+	intVariables  = []string{"a", "b", "c", "d"}
+	boolVariables = []string{"p", "q"}
+)
+
+var errDivisionByZero = errors.New("division by zero")
+
+// expr is a generated Jack expression. Binary operations are evaluated from
+// left to right without precedence, like Jack.
+type expr struct {
+	kind        string // "int", "bool", "var", "unary", "binary"
+	value       int16
+	name        string
+	op          string
+	left, right *expr
+}
+
+func (e *expr) source() string {
+	if e.kind == "binary" {
+		return e.left.source() + " " + e.op + " " + e.right.term()
+	}
+
+	return e.term()
+}
+
+// term renders e as a term, parenthesizing binary operations.
+func (e *expr) term() string {
+	switch e.kind {
+	case "int":
+		return fmt.Sprint(e.value)
+	case "bool":
+		if e.value != 0 {
+			return "true"
+		}
+		return "false"
+	case "var":
+		return e.name
+	case "unary":
+		return e.op + e.left.term()
+	default:
+		return "(" + e.source() + ")"
+	}
+}
+
+func (e *expr) eval(env map[string]int16) (int16, error) {
+	switch e.kind {
+	case "int", "bool":
+		return e.value, nil
+	case "var":
+		return env[e.name], nil
+	case "unary":
+		x, err := e.left.eval(env)
+		if e.op == "-" {
+			return -x, err
+		}
+		return ^x, err
+	}
+
+	x, err := e.left.eval(env)
+	if err != nil {
+		return 0, err
+	}
+	y, err := e.right.eval(env)
+	if err != nil {
+		return 0, err
+	}
+
+	switch e.op {
+	case "+":
+		return x + y, nil
+	case "-":
+		return x - y, nil
+	case "*":
+		return x * y, nil
+	case "/":
+		if y == 0 {
+			return 0, errDivisionByZero
+		}
+		return int16(int(x) / int(y)), nil
+	case "&":
+		return x & y, nil
+	case "|":
+		return x | y, nil
+	case "<":
+		return boolValue(x < y), nil
+	case ">":
+		return boolValue(x > y), nil
+	default:
+		return boolValue(x == y), nil
+	}
+}
+
+func boolValue(b bool) int16 {
+	if b {
+		return -1
+	}
+
+	return 0
+}
+
+// stmt is a generated Jack statement. Loops run a fixed number of times,
+// counted down by a variable of their own.
+type stmt struct {
+	kind         string // "let", "if", "while"
+	name         string
+	value        *expr
+	body, els    []*stmt
+	count        int16
+	loopVariable string
+}
+
+func (s *stmt) source(indent string) string {
+	switch s.kind {
+	case "let":
+		return fmt.Sprintf("%slet %s = %s;\n", indent, s.name, s.value.source())
+	case "if":
+		result := fmt.Sprintf("%sif (%s) {\n%s%s}", indent, s.value.source(), statementsSource(s.body, indent+"  "), indent)
+		if s.els != nil {
+			result += fmt.Sprintf(" else {\n%s%s}", statementsSource(s.els, indent+"  "), indent)
+		}
+		return result + "\n"
+	default:
+		return fmt.Sprintf("%slet %s = %d;\n%swhile (%s > 0) {\n%s%s  let %s = %s - 1;\n%s}\n",
+			indent, s.loopVariable, s.count,
+			indent, s.loopVariable,
+			statementsSource(s.body, indent+"  "),
+			indent, s.loopVariable, s.loopVariable,
+			indent)
+	}
+}
+
+func statementsSource(statements []*stmt, indent string) string {
+	result := ""
+	for _, s := range statements {
+		result += s.source(indent)
+	}
+
+	return result
+}
+
+func (s *stmt) exec(env map[string]int16) error {
+	switch s.kind {
+	case "let":
+		value, err := s.value.eval(env)
+		env[s.name] = value
+		return err
+	case "if":
+		cond, err := s.value.eval(env)
+		if err != nil {
+			return err
+		}
+
+		if cond != 0 {
+			return execStatements(s.body, env)
+		}
+		return execStatements(s.els, env)
+	default:
+		for env[s.loopVariable] = s.count; env[s.loopVariable] > 0; env[s.loopVariable]-- {
+			if err := execStatements(s.body, env); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+func execStatements(statements []*stmt, env map[string]int16) error {
+	for _, s := range statements {
+		if err := s.exec(env); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+type generator struct {
+	*rand.Rand
+	loops int
+}
+
+func (g *generator) pick(choices []string) string {
+	return choices[g.Intn(len(choices))]
+}
+
+func (g *generator) intExpr(depth int) *expr {
+	if depth <= 0 || g.Intn(3) == 0 {
+		switch g.Intn(3) {
+		case 0:
+			return &expr{kind: "var", name: g.pick(intVariables)}
+		case 1:
+			// small numbers make comparisons and division interesting
+			return &expr{kind: "int", value: int16(g.Intn(10))}
+		default:
+			return &expr{kind: "int", value: int16(g.Intn(32768))}
+		}
+	}
+
+	if g.Intn(5) == 0 {
+		return &expr{kind: "unary", op: g.pick([]string{"-", "~"}), left: g.intExpr(depth - 1)}
+	}
+
+	return &expr{
+		kind:  "binary",
+		op:    g.pick([]string{"+", "-", "*", "/", "&", "|"}),
+		left:  g.intExpr(depth - 1),
+		right: g.intExpr(depth - 1),
+	}
+}
+
+func (g *generator) boolExpr(depth int) *expr {
+	if depth <= 0 || g.Intn(4) == 0 {
+		if g.Intn(2) == 0 {
+			return &expr{kind: "var", name: g.pick(boolVariables)}
+		}
+		return &expr{kind: "bool", value: boolValue(g.Intn(2) == 0)}
+	}
+
+	switch g.Intn(3) {
+	case 0:
+		return &expr{kind: "unary", op: "~", left: g.boolExpr(depth - 1)}
+	case 1:
+		return &expr{
+			kind:  "binary",
+			op:    g.pick([]string{"&", "|"}),
+			left:  g.boolExpr(depth - 1),
+			right: g.boolExpr(depth - 1),
+		}
+	default:
+		return &expr{
+			kind:  "binary",
+			op:    g.pick([]string{"<", ">", "="}),
+			left:  g.intExpr(depth - 1),
+			right: g.intExpr(depth - 1),
+		}
+	}
+}
+
+func (g *generator) statements(depth, count int) []*stmt {
+	statements := make([]*stmt, count)
+	for i := range statements {
+		statements[i] = g.statement(depth)
+	}
+
+	return statements
+}
+
+func (g *generator) statement(depth int) *stmt {
+	choice := g.Intn(6)
+	if depth <= 0 {
+		choice = 0
+	}
+
+	switch choice {
+	case 4:
+		s := &stmt{kind: "if", value: g.boolExpr(2), body: g.statements(depth-1, 1+g.Intn(3))}
+		if g.Intn(2) == 0 {
+			s.els = g.statements(depth-1, 1+g.Intn(3))
+		}
+		return s
+	case 5:
+		s := &stmt{kind: "while", count: int16(g.Intn(4)), loopVariable: fmt.Sprintf("i%d", g.loops)}
+		g.loops++
+		s.body = g.statements(depth-1, 1+g.Intn(3))
+		return s
+	default:
+		if g.Intn(3) == 0 {
+			return &stmt{kind: "let", name: g.pick(boolVariables), value: g.boolExpr(3)}
+		}
+		return &stmt{kind: "let", name: g.pick(intVariables), value: g.intExpr(4)}
+	}
+}
+
+func (g *generator) program() (string, []*stmt) {
+	g.loops = 0
+	statements := g.statements(2, 2+g.Intn(5))
+
+	loopVariables := []string{}
+	for i := 0; i < g.loops; i++ {
+		loopVariables = append(loopVariables, fmt.Sprintf("i%d", i))
+	}
+
+	declarations := "    var int " + strings.Join(append(append([]string{}, intVariables...), loopVariables...), ", ") + ";\n"
+	declarations += "    var boolean " + strings.Join(boolVariables, ", ") + ";\n"
+
+	output := ""
+	for _, name := range append(append([]string{}, intVariables...), boolVariables...) {
+		output += fmt.Sprintf("    do Output.printInt(%s);\n    do Output.printChar(32);\n", name)
+	}
+
+	return "class Main {\n  function void main() {\n" + declarations +
+		statementsSource(statements, "    ") + output +
+		"    return;\n  }\n}\n", statements
+}
+
+// expectedOutput runs statements on the reference evaluator and returns what
+// the program prints, or an error if it fails.
+func expectedOutput(statements []*stmt) (string, error) {
+	env := map[string]int16{}
+	if err := execStatements(statements, env); err != nil {
+		return "", err
+	}
+
+	output := ""
+	for _, name := range append(append([]string{}, intVariables...), boolVariables...) {
+		output += fmt.Sprintf("%d ", env[name])
+	}
+
+	return output, nil
+}
+
+func TestCompileMatchesReferenceEvaluator(t *testing.T) {
+	programs := 500
+	if testing.Short() {
+		programs = 50
+	}
+
+	for seed := int64(0); seed < int64(programs); seed++ {
+		g := &generator{Rand: rand.New(rand.NewSource(seed))}
+		source, statements := g.program()
+
+		code := Compile(parser.Parse(tokenizer.Tokenize(source)))
+		state, err := vmtest.Run([]vm.File{{Name: "Main.vm", Code: code}}, vmtest.Scenario{MaxSteps: 1000000})
+		if err != nil {
+			t.Fatalf("seed %d: %v\n%s", seed, err, source)
+		}
+
+		expected, evalErr := expectedOutput(statements)
+		if evalErr != nil {
+			if state.Err == "" {
+				t.Errorf("seed %d: expect the program to fail with %v, got output `%s`\n%s", seed, evalErr, state.Output, source)
+			}
+			continue
+		}
+
+		if state.Err != "" || state.Output != expected {
+			t.Errorf("seed %d: got `%s` (error: %s), want `%s`\n%s", seed, state.Output, state.Err, expected, source)
+		}
+	}
+}
+
+func TestExpressionsAreLeftAssociative(t *testing.T) {
+	code := compile(`
+class Main {
+  function int main() {
+    return 10 - 4 - 3 * 2;
+  }
+}
+`)
+
+	program, err := vm.NewProgram([]vm.File{{Name: "Main.vm", Code: code}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	machine, err := vm.New(program)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := machine.Run(1000); err != nil {
+		t.Fatal(err)
+	}
+
+	// ((10 - 4) - 3) * 2
+	if value := machine.RAM[vm.StackBase]; value != 6 {
+		t.Errorf("expect 6, got %d", value)
+	}
+}
@@ -0,0 +1,492 @@
+package compiler
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/uiureo/jack/parser"
+	"github.com/uiureo/jack/tokenizer"
+	"github.com/uiureo/jack/vm"
+	"github.com/uiureo/jack/vm/vmtest"
+)
+
+// runExt compiles a Main class written with the language extensions and
+// returns what it prints on the emulator.
+func runExt(t *testing.T, source string) string {
+	t.Helper()
+
+	code := Compile(parser.ParseExt(tokenizer.TokenizeExt(source)))
+	state, err := vmtest.Run([]vm.File{{Name: "Main.vm", Code: code}}, vmtest.Scenario{MaxSteps: 100000})
+	if err != nil {
+		t.Fatalf("%v\n%s", err, code)
+	}
+	if state.Err != "" {
+		t.Fatalf("runtime error: %s\n%s", state.Err, code)
+	}
+
+	return state.Output
+}
+
+func TestFor(t *testing.T) {
+	output := runExt(t, `
+class Main {
+  function void main() {
+    var int i, j;
+    for (let i = 0; i < 3; let i = i + 1) {
+      for (let j = 0; j < i; let j = j + 1) {
+        do Output.printInt(j);
+      }
+      do Output.printChar(32);
+    }
+    do Output.printInt(i);
+    return;
+  }
+}`)
+
+	if output != " 0 01 3" {
+		t.Errorf("got `%s`", output)
+	}
+}
+
+func TestBreakAndContinue(t *testing.T) {
+	output := runExt(t, `
+class Main {
+  function void main() {
+    var int i, j;
+    for (let i = 0; i < 10; let i = i + 1) {
+      if (i = 2) {
+        continue;
+      }
+      if (i = 5) {
+        break;
+      }
+
+      let j = 0;
+      while (true) {
+        let j = j + 1;
+        if (j > 1) {
+          break;
+        }
+        do Output.printInt(i);
+      }
+    }
+    return;
+  }
+}`)
+
+	if output != "0134" {
+		t.Errorf("got `%s`", output)
+	}
+}
+
+func TestBreakOutsideOfLoop(t *testing.T) {
+	errs := Check(parser.ParseExt(tokenizer.TokenizeExt(`
+class Main {
+  function void main() {
+    if (true) {
+      break;
+    }
+    while (true) {
+      continue;
+    }
+    continue;
+    return;
+  }
+}`)))
+
+	expected := []string{
+		"5:7: Main.main: `break` outside of a loop",
+		"10:5: Main.main: `continue` outside of a loop",
+	}
+
+	if len(errs) != len(expected) {
+		t.Fatalf("expect %d errors, got %v", len(expected), errs)
+	}
+
+	for i, err := range errs {
+		if err.Error() != expected[i] {
+			t.Errorf("`%v`, want `%v`", err, expected[i])
+		}
+	}
+}
+
+func TestElseIf(t *testing.T) {
+	output := runExt(t, `
+class Main {
+  function void main() {
+    var int i;
+    for (let i = 0; i < 4; let i = i + 1) {
+      do Output.printInt(Main.sign(i - 1));
+    }
+    return;
+  }
+
+  function int sign(int n) {
+    if (n < 0) {
+      return -1;
+    } else if (n = 0) {
+      return 0;
+    } else {
+      return 1;
+    }
+  }
+}`)
+
+	if output != "-1011" {
+		t.Errorf("got `%s`", output)
+	}
+}
+
+func TestSwitch(t *testing.T) {
+	output := runExt(t, `
+class Main {
+  function void main() {
+    var int i;
+    for (let i = 0; i < 6; let i = i + 1) {
+      switch (i) {
+        case 1:
+          do Output.printChar(65);
+        case 3:
+          if (i = 3) {
+            break;
+          }
+          do Output.printChar(66);
+        case 4:
+          continue;
+        default:
+          do Output.printInt(i);
+      }
+      do Output.printChar(46);
+    }
+    do Output.printInt(Main.name(2));
+    return;
+  }
+
+  function int name(int key) {
+    switch (key) {
+      case 2:
+        return 20;
+      default:
+        return 0;
+    }
+  }
+}`)
+
+	if output != "0.A.2..5.20" {
+		t.Errorf("got `%s`", output)
+	}
+}
+
+func TestSwitchConstantLabels(t *testing.T) {
+	output := runExt(t, `
+class Main {
+  const int ONE = 1;
+  enum Color { RED, GREEN }
+
+  function void main() {
+    var int i;
+    for (let i = -2; i < 3; let i = i + 1) {
+      switch (i) {
+        case -1:
+          do Output.printChar(65);
+        case Main.ONE:
+          do Output.printChar(66);
+        case Main.RED:
+          do Output.printChar(67);
+        case -2:
+          do Output.printChar(68);
+        default:
+          do Output.printInt(i);
+      }
+    }
+    return;
+  }
+}`)
+
+	if output != "DACB2" {
+		t.Errorf("got `%s`", output)
+	}
+}
+
+func TestSwitchErrors(t *testing.T) {
+	errs := Check(parser.ParseExt(tokenizer.TokenizeExt(`
+class Main {
+  const int ONE = 1;
+
+  function int main() {
+    switch (1) {
+      case 1:
+        continue;
+      case 01:
+        break;
+      case Main.ONE:
+      case -1:
+      default:
+      default:
+        return 0;
+    }
+  }
+}`)))
+
+	expected := []string{
+		"8:9: Main.main: `continue` outside of a loop",
+		"9:7: Main.main: duplicate case `1`",
+		"11:7: Main.main: duplicate case `Main.ONE`",
+		"14:7: Main.main: duplicate `default` case",
+		"17:3: Main.main: missing return at end of subroutine",
+	}
+
+	if len(errs) != len(expected) {
+		t.Fatalf("expect %d errors, got %v", len(expected), errs)
+	}
+
+	for i, err := range errs {
+		if err.Error() != expected[i] {
+			t.Errorf("`%v`, want `%v`", err, expected[i])
+		}
+	}
+}
+
+func TestShortCircuit(t *testing.T) {
+	output := runExt(t, `
+class Main {
+  function void main() {
+    do Output.printInt(false && Main.mark(1));
+    do Output.printInt(true || Main.mark(2));
+    do Output.printInt(true && Main.mark(3));
+    do Output.printInt(false || Main.mark(4));
+    do Output.printInt(1 && 2);
+    do Output.printInt(false || false && Main.mark(5));
+    return;
+  }
+
+  function int mark(int n) {
+    do Output.printChar(91);
+    do Output.printInt(n);
+    do Output.printChar(93);
+    return n;
+  }
+}`)
+
+	if output != "0-1[3]-1[4]-1-10" {
+		t.Errorf("got `%s`", output)
+	}
+}
+
+func TestComparisonOperators(t *testing.T) {
+	output := runExt(t, `
+class Main {
+  function void main() {
+    var int i;
+    for (let i = 1; i < 4; let i = i + 1) {
+      do Output.printInt(i != 2);
+      do Output.printInt(i <= 2);
+      do Output.printInt(i >= 2);
+      do Output.printChar(32);
+    }
+    return;
+  }
+}`)
+
+	if output != "-1-10 0-1-1 -10-1 " {
+		t.Errorf("got `%s`", output)
+	}
+}
+
+func TestCompoundAssignment(t *testing.T) {
+	output := runExt(t, `
+class Main {
+  static int calls;
+
+  function int next() {
+    calls++;
+    return calls;
+  }
+
+  function void main() {
+    var int i, x;
+    var Array a;
+    let a = Array.new(4);
+    for (i = 0; i < 4; i++) {
+      a[i] = i * 10;
+    }
+    x = 5;
+    x += 2 * 3;
+    x -= 1 + 1;
+    x--;
+    do Output.printInt(x);
+    do Output.printChar(32);
+    a[Main.next()] += 5;
+    a[Main.next()]++;
+    a[3] -= a[1];
+    do Output.printInt(calls);
+    for (i = 0; i < 4; i += 1) {
+      do Output.printChar(32);
+      do Output.printInt(a[i]);
+    }
+    return;
+  }
+}`)
+
+	// x += e adds all of e: 5 + 6 - 2 - 1; a[next()] calls next() once
+	if output != "8 2 0 15 21 15" {
+		t.Errorf("got `%s`", output)
+	}
+}
+
+func TestArithmeticOperators(t *testing.T) {
+	output := runExt(t, `
+class Main {
+  function void main() {
+    var int n;
+    let n = 2;
+    do Output.printInt(-7 % 3);
+    do Output.printChar(32);
+    do Output.printInt(-3 << 2);
+    do Output.printChar(32);
+    do Output.printInt(-3 << n);
+    do Output.printChar(32);
+    do Output.printInt(-7 >> 1);
+    do Output.printChar(32);
+    do Output.printInt(-1 ^ 5);
+    do Output.printChar(32);
+    do Output.printInt(1 << 15);
+    return;
+  }
+}`)
+
+	if output != "-1 -12 -12 -4 -6 -32768" {
+		t.Errorf("got `%s`", output)
+	}
+}
+
+func TestConstantShiftIsInlined(t *testing.T) {
+	code := Compile(parser.ParseExt(tokenizer.TokenizeExt(`
+class Main {
+  function int main(int x) {
+    return (x << 2) + (x << 16);
+  }
+}`)))
+
+	if strings.Count(code, "add\n") != 3 || strings.Count(code, "call Runtime.shiftLeft 2") != 1 {
+		t.Errorf("expect `x << 2` to add twice and `x << 16` to call Runtime.shiftLeft, got:\n%s", code)
+	}
+}
+
+func TestConditional(t *testing.T) {
+	output := runExt(t, `
+class Main {
+  function int sign(int x) {
+    return x > 0 ? 1 : x < 0 ? -1 : 0;
+  }
+
+  function void main() {
+    var int x;
+    do Output.printInt(Main.sign(5));
+    do Output.printInt(Main.sign(-5));
+    do Output.printInt(Main.sign(0));
+    do Output.printChar(32);
+    let x = 2;
+    do Output.printInt((x = 2 ? 10 : 20) + 1);
+    do Output.printChar(32);
+    do Output.printInt(x - 2 ? 10 : 20 + 1);
+    return;
+  }
+}`)
+
+	// the operand of `+` needs parentheses, as `?` binds loosest
+	if output != "1-10 11 21" {
+		t.Errorf("got `%s`", output)
+	}
+}
+
+func TestLiterals(t *testing.T) {
+	output := runExt(t, `
+class Main {
+  function void main() {
+    var String s;
+    do Output.printChar('A');
+    do Output.printInt(0x7FFF & 0b1010);
+    let s = "";
+    do Output.printInt(s.length());
+    do Output.printString("say \"hi\"\\");
+    let s = "\n";
+    do Output.printInt(s.charAt(0));
+    return;
+  }
+}`)
+
+	if output != `A100say "hi"\128` {
+		t.Errorf("got `%s`", output)
+	}
+}
+
+func TestConstAndEnum(t *testing.T) {
+	Constants = map[string]int{"Other.LIMIT": 7}
+	defer func() { Constants = map[string]int{} }()
+
+	output := runExt(t, `
+class Main {
+  const int SIZE = 0x10;
+  const int MIN = -5;
+  const boolean DEBUG = true;
+  enum Direction { UP, DOWN, LEFT, RIGHT }
+  static int count;
+
+  function void main() {
+    var Direction d;
+    let count = 1;
+    let d = LEFT;
+    do Output.printInt(SIZE + Main.MIN);
+    do Output.printInt(d);
+    do Output.printInt(Main.RIGHT + Other.LIMIT);
+    do Output.printInt(DEBUG);
+    do Output.printInt(count);
+    switch (d) {
+      case 2:
+        do Output.printChar(76);
+    }
+    return;
+  }
+}`)
+
+	if output != "11210-11L" {
+		t.Errorf("got `%s`", output)
+	}
+}
+
+func TestConstErrors(t *testing.T) {
+	tests := map[string]string{
+		"let UP = 2;":           "cannot assign to constant `UP`",
+		"let x = Other.UP;":     "constant `Other.UP` is not defined",
+		"let x = Main.DOWN;":    "constant `Main.DOWN` is not defined",
+		"do UP.move();":         "constant `UP` has no methods",
+		"let x = UP[0];":        "constant `UP` is not an array",
+		"let x = Main.UP + UP;": "",
+	}
+
+	for statements, message := range tests {
+		func() {
+			defer func() {
+				r := recover()
+				if message == "" && r != nil {
+					t.Errorf("%s: unexpected error: %v", statements, r)
+				}
+				if message != "" && r != message {
+					t.Errorf("%s: expect error `%s`, got %v", statements, message, r)
+				}
+			}()
+
+			Compile(parser.ParseExt(tokenizer.TokenizeExt(`
+class Main {
+  const int UP = 1;
+
+  function void main() {
+    var int x;
+    ` + statements + `
+    return;
+  }
+}`)))
+		}()
+	}
+}
@@ -0,0 +1,55 @@
+package compiler
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/uiureo/jack/parser"
+	"github.com/uiureo/jack/tokenizer"
+	"github.com/uiureo/jack/vm"
+)
+
+// FuzzCompile checks that programs which parse and pass Check compile to VM
+// code the emulator loads. Compile errors such as undefined variables are
+// fine, crashes are not.
+func FuzzCompile(f *testing.F) {
+	files, _ := filepath.Glob("fixtures/*/*.jack")
+	for _, file := range files {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(string(data))
+	}
+
+	f.Fuzz(func(t *testing.T, source string) {
+		code, ok := tryCompile(t, source)
+		if !ok {
+			return
+		}
+
+		if _, err := vm.NewProgram([]vm.File{{Name: "Main.vm", Code: code}}); err != nil {
+			t.Fatalf("invalid VM code: %v\n%s", err, code)
+		}
+	})
+}
+
+func tryCompile(t *testing.T, source string) (code string, ok bool) {
+	defer func() {
+		if r := recover(); r != nil {
+			if err, crashed := r.(runtime.Error); crashed {
+				t.Fatalf("crashed: %v", err)
+			}
+			ok = false
+		}
+	}()
+
+	node := parser.Parse(tokenizer.Tokenize(source))
+	if node == nil || len(Check(node)) > 0 {
+		return "", false
+	}
+
+	return Compile(node), true
+}
@@ -0,0 +1,68 @@
+package compiler
+
+import "regexp"
+
+// RuntimeSource is the Runtime class that the operators `%`, `<<`, `>>` and
+// `^` of the language extensions call, written in plain Jack so that it
+// compiles and runs on the nand2tetris tools. vm/runtime.go is the
+// reference implementation of the same functions.
+const RuntimeSource = `// Runtime implements the operators %, <<, >> and ^ of the Jack language
+// extensions.
+class Runtime {
+
+  /** Returns the remainder of x / y, which has the sign of x. */
+  function int mod(int x, int y) {
+    if (y = 0) {
+      do Sys.error(22);
+    }
+    return x - ((x / y) * y);
+  }
+
+  /** Returns x shifted left by n bits, 0 when n is 16 or more. */
+  function int shiftLeft(int x, int n) {
+    if (n < 0) {
+      do Sys.error(23);
+    }
+    if (n > 16) {
+      let n = 16;
+    }
+    while (n > 0) {
+      let x = x + x;
+      let n = n - 1;
+    }
+    return x;
+  }
+
+  /** Returns x shifted right by n bits, copying the sign bit. */
+  function int shiftRight(int x, int n) {
+    if (n < 0) {
+      do Sys.error(23);
+    }
+    if (n > 16) {
+      let n = 16;
+    }
+    while (n > 0) {
+      if (x < 0) {
+        // ~x is not negative, and ~(~x / 2) rounds x / 2 down
+        let x = ~((~x) / 2);
+      } else {
+        let x = x / 2;
+      }
+      let n = n - 1;
+    }
+    return x;
+  }
+
+  /** Returns the bitwise exclusive or of x and y. */
+  function int xor(int x, int y) {
+    return (x | y) & (~(x & y));
+  }
+}
+`
+
+var runtimeCall = regexp.MustCompile(`call Runtime\.(mod|shiftLeft|shiftRight|xor) `)
+
+// UsesRuntime reports whether VM code calls the functions of RuntimeSource.
+func UsesRuntime(code string) bool {
+	return runtimeCall.MatchString(code)
+}
@@ -0,0 +1,108 @@
+package compiler
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/uiureo/jack/parser"
+	"github.com/uiureo/jack/tokenizer"
+	"github.com/uiureo/jack/vm"
+)
+
+// TestRuntimeMatchesReference runs the functions of RuntimeSource on the
+// emulator and compares them with the builtins of vm/runtime.go, including
+// the errors they stop the program with.
+func TestRuntimeMatchesReference(t *testing.T) {
+	values := []int{-32768, -12345, -7, -2, -1, 0, 1, 2, 7, 12345, 32767}
+
+	calls := [][]int{}
+	for _, x := range values {
+		for _, y := range []int{-7, -1, 1, 2, 3, 16} {
+			calls = append(calls, []int{0, x, y})
+		}
+		for n := 0; n <= 17; n++ {
+			calls = append(calls, []int{1, x, n}, []int{2, x, n})
+		}
+		for _, y := range values {
+			calls = append(calls, []int{3, x, y})
+		}
+	}
+
+	main := ""
+	for _, call := range calls {
+		main += pushRuntimeCall(call)
+		main += "call Output.printInt 1\npop temp 0\npush constant 32\ncall Output.printChar 1\npop temp 0\n"
+	}
+
+	machine, output, err := runRuntime(main)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	results := strings.Fields(output)
+	for i, call := range calls {
+		expected, err := callReference(machine, call)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if results[i] != fmt.Sprint(expected) {
+			t.Errorf("%s(%d, %d) = %s, want %d", runtimeFunctions[call[0]], call[1], call[2], results[i], expected)
+		}
+	}
+
+	for _, call := range [][]int{{0, 7, 0}, {0, -32768, 0}, {1, 1, -1}, {1, 0, -32768}, {2, -7, -1}, {2, 1, -16}} {
+		machine, _, err := runRuntime(pushRuntimeCall(call) + "pop temp 0\n")
+		_, expected := callReference(machine, call)
+		if err == nil || expected == nil || err.Error() != expected.Error() {
+			t.Errorf("%s(%d, %d): error `%v`, want `%v`", runtimeFunctions[call[0]], call[1], call[2], err, expected)
+		}
+	}
+}
+
+var runtimeFunctions = []string{"Runtime.mod", "Runtime.shiftLeft", "Runtime.shiftRight", "Runtime.xor"}
+
+// pushRuntimeCall calls a function of runtimeFunctions, call[0], with the
+// arguments call[1:].
+func pushRuntimeCall(call []int) string {
+	result := ""
+	for _, value := range call[1:] {
+		if value == -32768 {
+			result += "push constant 32767\nnot\n"
+		} else {
+			result += pushConstant(value)
+		}
+	}
+
+	return result + fmt.Sprintf("call %s 2\n", runtimeFunctions[call[0]])
+}
+
+// callReference calls the builtin of vm/runtime.go for a call of
+// pushRuntimeCall.
+func callReference(machine *vm.Machine, call []int) (int16, error) {
+	return machine.Builtins[runtimeFunctions[call[0]]](machine, []int16{int16(call[1]), int16(call[2])})
+}
+
+// runRuntime runs the body of Main.main with RuntimeSource and returns the
+// machine and its output.
+func runRuntime(body string) (*vm.Machine, string, error) {
+	program, err := vm.NewProgram([]vm.File{
+		{Name: "Main.vm", Code: "function Main.main 0\n" + body + "push constant 0\nreturn\n"},
+		{Name: "Runtime.vm", Code: Compile(parser.Parse(tokenizer.Tokenize(RuntimeSource)))},
+	})
+	if err != nil {
+		return nil, "", err
+	}
+
+	machine, err := vm.New(program)
+	if err != nil {
+		return nil, "", err
+	}
+	output := &bytes.Buffer{}
+	machine.Output = output
+	err = machine.Run(10000000)
+
+	return machine, output.String(), err
+}
@@ -0,0 +1,130 @@
+package compiler
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/uiureo/jack/parser"
+)
+
+// SourceMap maps the instructions of a compiled .vm file back to the Jack
+// source they were generated from. Instructions are numbered from 0 and every
+// command of the .vm file counts, including labels.
+type SourceMap struct {
+	VMFile      string       `json:"vmFile"`
+	JackFile    string       `json:"jackFile"`
+	Mappings    []Mapping    `json:"mappings"`
+	Subroutines []Subroutine `json:"subroutines"`
+}
+
+// Mapping marks the first instruction generated for a statement or a
+// subroutine declaration. It covers every instruction up to the next mapping.
+type Mapping struct {
+	Instruction int `json:"instruction"`
+	Line        int `json:"line"`
+	Column      int `json:"column"`
+}
+
+// Subroutine is the range of instructions [Start, End) of a VM function.
+type Subroutine struct {
+	Name  string `json:"name"`
+	Start int    `json:"start"`
+	End   int    `json:"end"`
+	Line  int    `json:"line"`
+}
+
+// CompileWithSourceMap compiles a class like Compile and also returns the
+// source map of the generated code. If comments is true, the code of each
+// statement is preceded by a `// Main.jack:12` comment.
+func CompileWithSourceMap(node *parser.Node, jackFile string, comments bool) (string, *SourceMap) {
+	jackFile = filepath.Base(jackFile)
+
+	sourceMap := &SourceMap{
+		VMFile:      strings.TrimSuffix(jackFile, filepath.Ext(jackFile)) + ".vm",
+		JackFile:    jackFile,
+		Mappings:    []Mapping{},
+		Subroutines: []Subroutine{},
+	}
+
+	result := ""
+	instruction := 0
+
+	for _, line := range strings.SplitAfter(compileClass(node), "\n") {
+		if len(line) == 0 {
+			continue
+		}
+
+		if strings.HasPrefix(line, "// @") {
+			mapping := Mapping{Instruction: instruction}
+			fmt.Sscanf(line[len("// @"):], "%d:%d", &mapping.Line, &mapping.Column)
+			sourceMap.Mappings = append(sourceMap.Mappings, mapping)
+
+			if comments {
+				result += fmt.Sprintf("// %s:%d\n", jackFile, mapping.Line)
+			}
+			continue
+		}
+
+		if strings.HasPrefix(line, "function ") {
+			sourceMap.closeSubroutine(instruction)
+
+			subroutine := Subroutine{Name: strings.Fields(line)[1], Start: instruction}
+			if mapping := sourceMap.Lookup(instruction); mapping != nil {
+				subroutine.Line = mapping.Line
+			}
+			sourceMap.Subroutines = append(sourceMap.Subroutines, subroutine)
+		}
+
+		result += line
+		instruction++
+	}
+
+	sourceMap.closeSubroutine(instruction)
+
+	return result, sourceMap
+}
+
+func (sourceMap *SourceMap) closeSubroutine(end int) {
+	if len(sourceMap.Subroutines) > 0 {
+		sourceMap.Subroutines[len(sourceMap.Subroutines)-1].End = end
+	}
+}
+
+// Lookup returns the mapping covering the instruction, or nil if there is
+// none.
+func (sourceMap *SourceMap) Lookup(instruction int) *Mapping {
+	i := sort.Search(len(sourceMap.Mappings), func(i int) bool {
+		return sourceMap.Mappings[i].Instruction > instruction
+	})
+
+	if i == 0 {
+		return nil
+	}
+
+	return &sourceMap.Mappings[i-1]
+}
+
+// Subroutine returns the subroutine containing the instruction, or nil if
+// there is none.
+func (sourceMap *SourceMap) Subroutine(instruction int) *Subroutine {
+	for i, subroutine := range sourceMap.Subroutines {
+		if subroutine.Start <= instruction && instruction < subroutine.End {
+			return &sourceMap.Subroutines[i]
+		}
+	}
+
+	return nil
+}
+
+// sourceMarker returns a marker line recording the position of node. The
+// markers are consumed by CompileWithSourceMap and never reach the output.
+func sourceMarker(node *parser.Node) string {
+	line, column := node.Pos()
+	if line == 0 {
+		return ""
+	}
+
+	return fmt.Sprintf("// @%d:%d\n", line, column)
+}
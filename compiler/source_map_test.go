@@ -0,0 +1,86 @@
+package compiler
+
+import (
+	"testing"
+
+	"github.com/uiureo/jack/parser"
+	"github.com/uiureo/jack/tokenizer"
+)
+
+const sourceMapExample = `class Main {
+  function void main() {
+    var int i;
+    let i = 0;
+    while (i < 3) {
+      let i = i + 1;
+    }
+    return;
+  }
+
+  function int one() {
+    return 1;
+  }
+}`
+
+func TestCompileWithSourceMap(t *testing.T) {
+	code, sourceMap := CompileWithSourceMap(parser.Parse(tokenizer.Tokenize(sourceMapExample)), "src/Main.jack", false)
+
+	compare(t, "", code, Compile(parser.Parse(tokenizer.Tokenize(sourceMapExample))))
+
+	if sourceMap.VMFile != "Main.vm" || sourceMap.JackFile != "Main.jack" {
+		t.Errorf("unexpected files: %v, %v", sourceMap.VMFile, sourceMap.JackFile)
+	}
+
+	expectedMappings := []Mapping{
+		{0, 2, 17}, // function Main.main 1
+		{1, 4, 5},  // let i = 0;
+		{3, 5, 5},  // while (i < 3)
+		{9, 6, 7},  // let i = i + 1;
+		{15, 8, 5}, // return;
+		{17, 11, 16},
+		{18, 12, 5},
+	}
+
+	if len(sourceMap.Mappings) != len(expectedMappings) {
+		t.Fatalf("expect %v, got %v", expectedMappings, sourceMap.Mappings)
+	}
+
+	for i, mapping := range sourceMap.Mappings {
+		if mapping != expectedMappings[i] {
+			t.Errorf("expect %v, got %v", expectedMappings[i], mapping)
+		}
+	}
+
+	expectedSubroutines := []Subroutine{
+		{Name: "Main.main", Start: 0, End: 17, Line: 2},
+		{Name: "Main.one", Start: 17, End: 20, Line: 11},
+	}
+
+	for i, subroutine := range sourceMap.Subroutines {
+		if subroutine != expectedSubroutines[i] {
+			t.Errorf("expect %v, got %v", expectedSubroutines[i], subroutine)
+		}
+	}
+
+	if mapping := sourceMap.Lookup(12); mapping == nil || mapping.Line != 6 {
+		t.Errorf("Lookup(12) returns %v, want line 6", mapping)
+	}
+
+	if subroutine := sourceMap.Subroutine(19); subroutine == nil || subroutine.Name != "Main.one" {
+		t.Errorf("Subroutine(19) returns %v, want Main.one", subroutine)
+	}
+}
+
+func TestCompileWithSourceComments(t *testing.T) {
+	code, _ := CompileWithSourceMap(parser.Parse(tokenizer.Tokenize(sourceMapExample)), "Main.jack", true)
+
+	compare(t, "", code, `
+    // Main.jack:2
+    function Main.main 1
+    // Main.jack:4
+    push constant 0
+    pop local 0
+    // Main.jack:5
+    label WHILE_EXP0
+  `)
+}
@@ -3,9 +3,12 @@ package compiler
 import "fmt"
 
 type Symbol struct {
-	// Kind: var, argument, static, field, class, subroutine
+	// Kind: var, argument, static, field, class, subroutine, const
 	SymbolType, Kind string
-	Number           int
+
+	// Number is the index in the segment of the kind, or the value of a
+	// const.
+	Number int
 }
 
 type SymbolTable struct {
@@ -50,8 +53,14 @@ func (table *SymbolTable) Find(query *Symbol) *Symbol {
 	return nil
 }
 
+// Set declares a symbol in the current scope, numbering it after the
+// symbols of the same kind, except for consts, which keep their value.
 func (table *SymbolTable) Set(name string, symbol *Symbol) {
 	currentScope := table.Scopes[0]
+	if symbol.Kind == "const" {
+		currentScope[name] = symbol
+		return
+	}
 
 	kindCount := 0
 	for _, item := range currentScope {
@@ -0,0 +1,83 @@
+package dap
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Message is a Debug Adapter Protocol message: a request, a response or an
+// event, depending on Type.
+type Message struct {
+	Seq  int    `json:"seq"`
+	Type string `json:"type"`
+
+	// request
+	Command   string          `json:"command,omitempty"`
+	Arguments json.RawMessage `json:"arguments,omitempty"`
+
+	// response
+	RequestSeq int    `json:"request_seq,omitempty"`
+	Success    *bool  `json:"success,omitempty"`
+	ErrMessage string `json:"message,omitempty"`
+
+	// event
+	Event string `json:"event,omitempty"`
+
+	Body interface{} `json:"body,omitempty"`
+}
+
+// ReadMessage reads a message framed by a Content-Length header.
+func ReadMessage(r *bufio.Reader) (*Message, error) {
+	length := -1
+
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+
+		line = strings.TrimSpace(line)
+		if line == "" {
+			break
+		}
+
+		if i := strings.Index(line, ":"); i >= 0 && strings.EqualFold(line[:i], "Content-Length") {
+			length, err = strconv.Atoi(strings.TrimSpace(line[i+1:]))
+			if err != nil {
+				return nil, fmt.Errorf("invalid header `%s`", line)
+			}
+		}
+	}
+
+	if length < 0 {
+		return nil, fmt.Errorf("missing Content-Length header")
+	}
+
+	data := make([]byte, length)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, err
+	}
+
+	message := &Message{}
+	if err := json.Unmarshal(data, message); err != nil {
+		return nil, err
+	}
+
+	return message, nil
+}
+
+// WriteMessage writes a message framed by a Content-Length header.
+func WriteMessage(w io.Writer, message *Message) error {
+	data, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(w, "Content-Length: %d\r\n\r\n%s", len(data), data)
+
+	return err
+}
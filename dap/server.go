@@ -0,0 +1,536 @@
+package dap
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sync"
+
+	"github.com/uiureo/jack/debugger"
+	"github.com/uiureo/jack/project"
+	"github.com/uiureo/jack/vm"
+)
+
+const threadID = 1
+
+// scopes of a frame, in the order reported by the scopes request
+var scopes = []struct{ name, kind string }{
+	{"Locals", "local"},
+	{"Arguments", "argument"},
+	{"Fields", "field"},
+	{"Statics", "static"},
+}
+
+type source struct {
+	Name string `json:"name"`
+	Path string `json:"path"`
+}
+
+// Server serves one debug session over a pair of streams. The program runs
+// in its own goroutine, so that requests such as pause are served while it
+// runs.
+type Server struct {
+	in  *bufio.Reader
+	out io.Writer
+
+	// writing serializes the messages, which the running program sends too.
+	writing sync.Mutex
+	seq     int
+
+	// mu guards the fields below against the goroutine running the program.
+	mu          sync.Mutex
+	session     *debugger.Session
+	stopOnEntry bool
+
+	// While running, closing interrupt pauses the program, and done is
+	// closed once it has stopped for reason. resume is the command it runs.
+	running   bool
+	interrupt chan struct{}
+	done      chan struct{}
+	resume    func() (string, error)
+	reason    string
+
+	// quiet drops the stopped event of a pause that the server resumes from.
+	quiet bool
+
+	// err is the error of sending the events of the running program.
+	err error
+}
+
+// Serve handles requests from in and writes responses and events to out
+// until the client disconnects.
+func Serve(in io.Reader, out io.Writer) error {
+	server := &Server{in: bufio.NewReader(in), out: out}
+
+	for {
+		request, err := ReadMessage(server.in)
+		if err == io.EOF {
+			server.mu.Lock()
+			server.quiet = true
+			server.pause()
+			server.mu.Unlock()
+
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if request.Type != "request" {
+			continue
+		}
+
+		server.mu.Lock()
+		err = server.handle(request)
+		if err == nil {
+			err = server.err
+		}
+		server.mu.Unlock()
+
+		if err != nil {
+			return err
+		}
+
+		if request.Command == "disconnect" {
+			return nil
+		}
+	}
+}
+
+func (server *Server) send(message *Message) error {
+	server.writing.Lock()
+	defer server.writing.Unlock()
+
+	server.seq++
+	message.Seq = server.seq
+
+	return WriteMessage(server.out, message)
+}
+
+func (server *Server) respond(request *Message, body interface{}) error {
+	success := true
+
+	return server.send(&Message{
+		Type:       "response",
+		RequestSeq: request.Seq,
+		Command:    request.Command,
+		Success:    &success,
+		Body:       body,
+	})
+}
+
+func (server *Server) fail(request *Message, err error) error {
+	success := false
+
+	return server.send(&Message{
+		Type:       "response",
+		RequestSeq: request.Seq,
+		Command:    request.Command,
+		Success:    &success,
+		ErrMessage: err.Error(),
+	})
+}
+
+func (server *Server) event(event string, body interface{}) error {
+	return server.send(&Message{Type: "event", Event: event, Body: body})
+}
+
+func (server *Server) handle(request *Message) error {
+	if server.session == nil {
+		switch request.Command {
+		case "initialize", "launch", "disconnect":
+		default:
+			return server.fail(request, fmt.Errorf("no program is launched"))
+		}
+	}
+
+	if server.running {
+		switch request.Command {
+		case "threads", "pause", "setBreakpoints", "disconnect":
+		default:
+			return server.fail(request, fmt.Errorf("the program is running"))
+		}
+	}
+
+	switch request.Command {
+	case "initialize":
+		if err := server.respond(request, map[string]interface{}{
+			"supportsConfigurationDoneRequest": true,
+			"supportsEvaluateForHovers":        true,
+		}); err != nil {
+			return err
+		}
+
+		return server.event("initialized", nil)
+	case "launch":
+		return server.launch(request)
+	case "setBreakpoints":
+		if !server.running {
+			return server.setBreakpoints(request)
+		}
+
+		// the breakpoints change while the program is paused
+		resume := server.resume
+		server.quiet = true
+		server.pause()
+		server.quiet = false
+
+		if err := server.setBreakpoints(request); err != nil {
+			return err
+		}
+
+		if server.reason == debugger.Paused {
+			return server.execute(resume)
+		}
+
+		return nil
+	case "configurationDone":
+		if err := server.respond(request, nil); err != nil {
+			return err
+		}
+
+		if server.stopOnEntry {
+			return server.stopped("entry", "")
+		}
+
+		return server.execute(server.session.Continue)
+	case "threads":
+		return server.respond(request, map[string]interface{}{
+			"threads": []map[string]interface{}{{"id": threadID, "name": "main"}},
+		})
+	case "stackTrace":
+		return server.stackTrace(request)
+	case "scopes":
+		return server.scopes(request)
+	case "variables":
+		return server.variables(request)
+	case "evaluate":
+		return server.evaluate(request)
+	case "continue":
+		if err := server.respond(request, map[string]interface{}{"allThreadsContinued": true}); err != nil {
+			return err
+		}
+
+		return server.execute(server.session.Continue)
+	case "next":
+		if err := server.respond(request, nil); err != nil {
+			return err
+		}
+
+		return server.execute(server.session.StepOver)
+	case "stepIn":
+		if err := server.respond(request, nil); err != nil {
+			return err
+		}
+
+		return server.execute(server.session.StepIn)
+	case "stepOut":
+		if err := server.respond(request, nil); err != nil {
+			return err
+		}
+
+		return server.execute(server.session.StepOut)
+	case "pause":
+		if err := server.respond(request, nil); err != nil {
+			return err
+		}
+
+		server.pause()
+
+		return nil
+	case "disconnect":
+		server.quiet = true
+		server.pause()
+
+		return server.respond(request, nil)
+	default:
+		return server.fail(request, fmt.Errorf("unsupported request `%s`", request.Command))
+	}
+}
+
+func (server *Server) launch(request *Message) error {
+	var args struct {
+		Program     string `json:"program"`
+		StopOnEntry bool   `json:"stopOnEntry"`
+		Input       string `json:"input"`
+	}
+
+	if err := json.Unmarshal(request.Arguments, &args); err != nil {
+		return server.fail(request, err)
+	}
+
+	p, err := project.Load(args.Program)
+	if err != nil {
+		return server.fail(request, err)
+	}
+
+	session, err := debugger.NewSession(p)
+	if err != nil {
+		return server.fail(request, err)
+	}
+
+	session.Machine.Output = &outputWriter{server}
+	session.Machine.Keyboard = vm.KeyboardInput(args.Input)
+
+	server.session = session
+	server.stopOnEntry = args.StopOnEntry
+
+	return server.respond(request, nil)
+}
+
+// outputWriter forwards the program output as output events.
+type outputWriter struct {
+	server *Server
+}
+
+func (writer *outputWriter) Write(p []byte) (int, error) {
+	err := writer.server.event("output", map[string]interface{}{"category": "stdout", "output": string(p)})
+
+	return len(p), err
+}
+
+func (server *Server) setBreakpoints(request *Message) error {
+	var args struct {
+		Source      source `json:"source"`
+		Breakpoints []struct {
+			Line int `json:"line"`
+		} `json:"breakpoints"`
+	}
+
+	if err := json.Unmarshal(request.Arguments, &args); err != nil {
+		return server.fail(request, err)
+	}
+
+	file := filepath.Base(args.Source.Path)
+	server.session.ClearBreakpoints(file)
+
+	breakpoints := []map[string]interface{}{}
+	for _, breakpoint := range args.Breakpoints {
+		location, err := server.session.SetBreakpoint(fmt.Sprintf("%s:%d", file, breakpoint.Line))
+		if err != nil {
+			breakpoints = append(breakpoints, map[string]interface{}{
+				"verified": false,
+				"line":     breakpoint.Line,
+				"message":  err.Error(),
+			})
+			continue
+		}
+
+		breakpoints = append(breakpoints, map[string]interface{}{
+			"verified": true,
+			"line":     location.Line,
+			"source":   sourceOf(location),
+		})
+	}
+
+	return server.respond(request, map[string]interface{}{"breakpoints": breakpoints})
+}
+
+func sourceOf(location debugger.Location) source {
+	path, _ := filepath.Abs(location.Class.JackFile)
+
+	return source{Name: filepath.Base(location.Class.JackFile), Path: path}
+}
+
+// execute resumes the program in a goroutine, which reports why it stopped.
+// The caller holds server.mu.
+func (server *Server) execute(resume func() (string, error)) error {
+	interrupt := make(chan struct{})
+	done := make(chan struct{})
+
+	server.session.Interrupt = interrupt
+	server.running = true
+	server.interrupt = interrupt
+	server.done = done
+	server.resume = resume
+
+	go func() {
+		defer close(done)
+
+		reason, err := resume()
+
+		server.mu.Lock()
+		defer server.mu.Unlock()
+
+		server.running = false
+		server.reason = reason
+
+		if err == nil && reason == debugger.Paused && server.quiet {
+			return
+		}
+
+		if err := server.report(reason, err); err != nil && server.err == nil {
+			server.err = err
+		}
+	}()
+
+	return nil
+}
+
+// pause interrupts the running program, if any, and waits until it has
+// stopped. The caller holds server.mu, which it releases meanwhile for the
+// program to report.
+func (server *Server) pause() {
+	if !server.running {
+		return
+	}
+
+	close(server.interrupt)
+	done := server.done
+
+	server.mu.Unlock()
+	<-done
+	server.mu.Lock()
+}
+
+// report sends the events telling why the program stopped.
+func (server *Server) report(reason string, err error) error {
+	if err != nil {
+		location := server.session.Location(server.session.Machine.PC)
+		server.session.Machine.Halted = true
+
+		return server.stopped("exception", fmt.Sprintf("runtime error at %s: %v", location, err))
+	}
+
+	switch reason {
+	case debugger.Exited:
+		if err := server.event("exited", map[string]interface{}{"exitCode": 0}); err != nil {
+			return err
+		}
+
+		return server.event("terminated", nil)
+	case debugger.Paused:
+		return server.stopped("pause", "")
+	default:
+		return server.stopped(reason, "")
+	}
+}
+
+func (server *Server) stopped(reason, text string) error {
+	body := map[string]interface{}{
+		"reason":            reason,
+		"threadId":          threadID,
+		"allThreadsStopped": true,
+	}
+	if text != "" {
+		body["text"] = text
+	}
+
+	return server.event("stopped", body)
+}
+
+func (server *Server) stackTrace(request *Message) error {
+	frames := []map[string]interface{}{}
+
+	for _, frame := range server.session.Stack() {
+		stackFrame := map[string]interface{}{
+			"id":     frame.Index + 1,
+			"name":   frame.Function,
+			"line":   frame.Line,
+			"column": frame.Column,
+		}
+		if frame.Class != nil {
+			stackFrame["source"] = sourceOf(frame.Location)
+		}
+
+		frames = append(frames, stackFrame)
+	}
+
+	return server.respond(request, map[string]interface{}{
+		"stackFrames": frames,
+		"totalFrames": len(frames),
+	})
+}
+
+// frame returns the frame of a frame id, which is its index in
+// vm.Machine.Frames plus one.
+func (server *Server) frame(id int) (debugger.Frame, error) {
+	for _, frame := range server.session.Stack() {
+		if frame.Index+1 == id {
+			return frame, nil
+		}
+	}
+
+	return debugger.Frame{}, fmt.Errorf("invalid frame id %d", id)
+}
+
+func (server *Server) scopes(request *Message) error {
+	var args struct {
+		FrameID int `json:"frameId"`
+	}
+
+	if err := json.Unmarshal(request.Arguments, &args); err != nil {
+		return server.fail(request, err)
+	}
+
+	if _, err := server.frame(args.FrameID); err != nil {
+		return server.fail(request, err)
+	}
+
+	result := []map[string]interface{}{}
+	for i, scope := range scopes {
+		result = append(result, map[string]interface{}{
+			"name":               scope.name,
+			"variablesReference": args.FrameID*len(scopes) + i,
+			"expensive":          false,
+		})
+	}
+
+	return server.respond(request, map[string]interface{}{"scopes": result})
+}
+
+func (server *Server) variables(request *Message) error {
+	var args struct {
+		VariablesReference int `json:"variablesReference"`
+	}
+
+	if err := json.Unmarshal(request.Arguments, &args); err != nil {
+		return server.fail(request, err)
+	}
+
+	frame, err := server.frame(args.VariablesReference / len(scopes))
+	if err != nil {
+		return server.fail(request, err)
+	}
+
+	result := []map[string]interface{}{}
+	for _, variable := range server.session.Variables(frame, scopes[args.VariablesReference%len(scopes)].kind) {
+		result = append(result, map[string]interface{}{
+			"name":               variable.Name,
+			"value":              server.session.Format(variable),
+			"type":               variable.Type,
+			"variablesReference": 0,
+		})
+	}
+
+	return server.respond(request, map[string]interface{}{"variables": result})
+}
+
+func (server *Server) evaluate(request *Message) error {
+	var args struct {
+		Expression string `json:"expression"`
+		FrameID    int    `json:"frameId"`
+	}
+
+	if err := json.Unmarshal(request.Arguments, &args); err != nil {
+		return server.fail(request, err)
+	}
+
+	frame, err := server.frame(args.FrameID)
+	if err != nil {
+		return server.fail(request, err)
+	}
+
+	variable, err := server.session.Lookup(frame, args.Expression)
+	if err != nil {
+		return server.fail(request, err)
+	}
+
+	return server.respond(request, map[string]interface{}{
+		"result":             server.session.Format(variable),
+		"type":               variable.Type,
+		"variablesReference": 0,
+	})
+}
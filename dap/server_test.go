@@ -0,0 +1,274 @@
+package dap
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"testing"
+)
+
+// client is a scripted DAP client talking to Serve over pipes.
+type client struct {
+	t   *testing.T
+	seq int
+	in  *io.PipeWriter
+	out *bufio.Reader
+}
+
+func newClient(t *testing.T) *client {
+	requestReader, requestWriter := io.Pipe()
+	responseReader, responseWriter := io.Pipe()
+
+	go func() {
+		if err := Serve(requestReader, responseWriter); err != nil {
+			t.Error(err)
+		}
+		responseWriter.Close()
+	}()
+
+	return &client{t: t, in: requestWriter, out: bufio.NewReader(responseReader)}
+}
+
+func (c *client) request(command string, arguments interface{}) {
+	data, _ := json.Marshal(arguments)
+	c.seq++
+
+	if err := WriteMessage(c.in, &Message{Seq: c.seq, Type: "request", Command: command, Arguments: data}); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+// expect reads the next message, which must be the response to command or
+// the event, and decodes its body into body.
+func (c *client) expect(kind, name string, body interface{}) *Message {
+	c.t.Helper()
+
+	message, err := ReadMessage(c.out)
+	if err != nil {
+		c.t.Fatalf("expect %s `%s`, got %v", kind, name, err)
+	}
+
+	if message.Type != kind || (message.Command != name && message.Event != name) {
+		c.t.Fatalf("expect %s `%s`, got %+v", kind, name, message)
+	}
+
+	if kind == "response" && !*message.Success {
+		c.t.Fatalf("%s failed: %s", name, message.ErrMessage)
+	}
+
+	if body != nil {
+		data, _ := json.Marshal(message.Body)
+		json.Unmarshal(data, body)
+	}
+
+	return message
+}
+
+type stackTrace struct {
+	StackFrames []struct {
+		ID     int    `json:"id"`
+		Name   string `json:"name"`
+		Line   int    `json:"line"`
+		Source struct {
+			Name string `json:"name"`
+		} `json:"source"`
+	} `json:"stackFrames"`
+}
+
+type variables struct {
+	Variables []struct {
+		Name  string `json:"name"`
+		Value string `json:"value"`
+	} `json:"variables"`
+}
+
+func TestDebugSession(t *testing.T) {
+	c := newClient(t)
+
+	c.request("initialize", map[string]interface{}{"adapterID": "jack"})
+	c.expect("response", "initialize", nil)
+	c.expect("event", "initialized", nil)
+
+	c.request("launch", map[string]interface{}{"program": "../debugger/fixtures/Point"})
+	c.expect("response", "launch", nil)
+
+	var breakpoints struct {
+		Breakpoints []struct {
+			Verified bool `json:"verified"`
+			Line     int  `json:"line"`
+		} `json:"breakpoints"`
+	}
+	c.request("setBreakpoints", map[string]interface{}{
+		"source":      map[string]string{"path": "/somewhere/Point.jack"},
+		"breakpoints": []map[string]int{{"line": 11}, {"line": 100}},
+	})
+	c.expect("response", "setBreakpoints", &breakpoints)
+
+	if len(breakpoints.Breakpoints) != 2 || !breakpoints.Breakpoints[0].Verified || breakpoints.Breakpoints[1].Verified {
+		t.Errorf("unexpected breakpoints %+v", breakpoints)
+	}
+
+	c.request("configurationDone", nil)
+	c.expect("response", "configurationDone", nil)
+
+	var stopped struct {
+		Reason string `json:"reason"`
+	}
+	c.expect("event", "stopped", &stopped)
+	if stopped.Reason != "breakpoint" {
+		t.Errorf("stopped by %s, want breakpoint", stopped.Reason)
+	}
+
+	c.request("threads", nil)
+	c.expect("response", "threads", nil)
+
+	var stack stackTrace
+	c.request("stackTrace", map[string]int{"threadId": 1})
+	c.expect("response", "stackTrace", &stack)
+
+	if len(stack.StackFrames) != 2 || stack.StackFrames[0].Name != "Point.move" || stack.StackFrames[0].Line != 11 || stack.StackFrames[1].Source.Name != "Main.jack" {
+		t.Fatalf("unexpected stack trace %+v", stack)
+	}
+
+	var scopes struct {
+		Scopes []struct {
+			Name               string `json:"name"`
+			VariablesReference int    `json:"variablesReference"`
+		} `json:"scopes"`
+	}
+	c.request("scopes", map[string]int{"frameId": stack.StackFrames[0].ID})
+	c.expect("response", "scopes", &scopes)
+
+	var fields variables
+	c.request("variables", map[string]int{"variablesReference": scopes.Scopes[2].VariablesReference})
+	c.expect("response", "variables", &fields)
+
+	if scopes.Scopes[2].Name != "Fields" || len(fields.Variables) != 2 || fields.Variables[0].Name != "x" || fields.Variables[0].Value != "1" {
+		t.Errorf("unexpected fields %+v", fields)
+	}
+
+	var result struct {
+		Result string `json:"result"`
+	}
+	c.request("evaluate", map[string]interface{}{"expression": "s", "frameId": stack.StackFrames[1].ID})
+	c.expect("response", "evaluate", &result)
+	if result.Result != `"hi"` {
+		t.Errorf("s = %s, want \"hi\"", result.Result)
+	}
+
+	c.request("next", map[string]int{"threadId": 1})
+	c.expect("response", "next", nil)
+	c.expect("event", "stopped", &stopped)
+
+	c.request("stepOut", map[string]int{"threadId": 1})
+	c.expect("response", "stepOut", nil)
+	c.expect("event", "stopped", nil)
+
+	c.request("stackTrace", map[string]int{"threadId": 1})
+	c.expect("response", "stackTrace", &stack)
+	if len(stack.StackFrames) != 1 || stack.StackFrames[0].Line != 9 {
+		t.Errorf("unexpected stack trace after stepOut %+v", stack)
+	}
+
+	c.request("stepIn", map[string]int{"threadId": 1})
+	c.expect("response", "stepIn", nil)
+	c.expect("event", "stopped", nil)
+
+	c.request("continue", map[string]int{"threadId": 1})
+	c.expect("response", "continue", nil)
+
+	var output struct {
+		Output string `json:"output"`
+	}
+	c.expect("event", "output", &output)
+	if output.Output != "4" {
+		t.Errorf("output `%s`, want `4`", output.Output)
+	}
+
+	c.expect("event", "exited", nil)
+	c.expect("event", "terminated", nil)
+
+	c.request("disconnect", nil)
+	c.expect("response", "disconnect", nil)
+}
+
+func TestLaunchFailure(t *testing.T) {
+	c := newClient(t)
+
+	c.request("launch", map[string]interface{}{"program": "no/such/dir"})
+	message, err := ReadMessage(c.out)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if *message.Success || message.ErrMessage == "" {
+		t.Errorf("expect launch to fail, got %+v", message)
+	}
+
+	c.request("stackTrace", nil)
+	if message, _ := ReadMessage(c.out); *message.Success {
+		t.Error("expect stackTrace to fail without a program")
+	}
+}
+
+func TestPause(t *testing.T) {
+	c := newClient(t)
+
+	c.request("initialize", map[string]interface{}{"adapterID": "jack"})
+	c.expect("response", "initialize", nil)
+	c.expect("event", "initialized", nil)
+
+	c.request("launch", map[string]interface{}{"program": "fixtures/Loop"})
+	c.expect("response", "launch", nil)
+
+	c.request("configurationDone", nil)
+	c.expect("response", "configurationDone", nil)
+
+	c.request("stackTrace", map[string]int{"threadId": 1})
+	if message, _ := ReadMessage(c.out); *message.Success || message.ErrMessage != "the program is running" {
+		t.Errorf("expect stackTrace to fail while running, got %+v", message)
+	}
+
+	var stopped struct {
+		Reason string `json:"reason"`
+	}
+	c.request("pause", map[string]int{"threadId": 1})
+	c.expect("response", "pause", nil)
+	c.expect("event", "stopped", &stopped)
+	if stopped.Reason != "pause" {
+		t.Errorf("stopped by %s, want pause", stopped.Reason)
+	}
+
+	var stack stackTrace
+	c.request("stackTrace", map[string]int{"threadId": 1})
+	c.expect("response", "stackTrace", &stack)
+	if len(stack.StackFrames) != 1 || stack.StackFrames[0].Name != "Main.main" {
+		t.Errorf("unexpected stack trace %+v", stack)
+	}
+
+	// breakpoints set while running pause the program and resume it
+	c.request("continue", map[string]int{"threadId": 1})
+	c.expect("response", "continue", nil)
+
+	c.request("setBreakpoints", map[string]interface{}{
+		"source":      map[string]string{"path": "Main.jack"},
+		"breakpoints": []map[string]int{{"line": 6}},
+	})
+	c.expect("response", "setBreakpoints", nil)
+	c.expect("event", "stopped", &stopped)
+	if stopped.Reason != "breakpoint" {
+		t.Errorf("stopped by %s, want breakpoint", stopped.Reason)
+	}
+
+	c.request("setBreakpoints", map[string]interface{}{
+		"source":      map[string]string{"path": "Main.jack"},
+		"breakpoints": []map[string]int{},
+	})
+	c.expect("response", "setBreakpoints", nil)
+
+	c.request("continue", map[string]int{"threadId": 1})
+	c.expect("response", "continue", nil)
+
+	c.request("disconnect", nil)
+	c.expect("response", "disconnect", nil)
+}
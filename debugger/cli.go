@@ -0,0 +1,167 @@
+package debugger
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+const help = `commands:
+  break FILE:LINE | Class.sub   set a breakpoint (b)
+  run, continue                 run to the next breakpoint (c)
+  step                          step to the next statement, entering calls (s)
+  next                          step over calls (n)
+  finish                        run until the current subroutine returns
+  backtrace                     show the call stack (bt)
+  frame N                       select frame N of the backtrace
+  print NAME                    show a variable (p)
+  locals, args, fields, statics show variables of the selected frame
+  quit                          exit the debugger (q)
+`
+
+// Run reads debugger commands line by line from in and writes the results,
+// as well as the program output, to out.
+func Run(session *Session, in io.Reader, out io.Writer) error {
+	session.Machine.Output = out
+	selected := 0
+
+	scanner := bufio.NewScanner(in)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+
+		command, args := fields[0], fields[1:]
+
+		if session.Machine.Halted && needsProgram(command) {
+			fmt.Fprintln(out, "the program is not running")
+			continue
+		}
+
+		var reason string
+		var err error
+
+		switch command {
+		case "break", "b":
+			if len(args) != 1 {
+				fmt.Fprintln(out, "usage: break FILE:LINE | Class.subroutine")
+				continue
+			}
+
+			location, err := session.SetBreakpoint(args[0])
+			if err != nil {
+				fmt.Fprintln(out, err)
+				continue
+			}
+			fmt.Fprintf(out, "breakpoint at %s in %s\n", location, location.Function)
+			continue
+		case "run", "continue", "c":
+			reason, err = session.Continue()
+		case "step", "s":
+			reason, err = session.StepIn()
+		case "next", "n":
+			reason, err = session.StepOver()
+		case "finish":
+			reason, err = session.StepOut()
+		case "backtrace", "bt":
+			for i, frame := range session.Stack() {
+				fmt.Fprintf(out, "#%d %s (%s)\n", i, frame.Function, frame.Location)
+			}
+			continue
+		case "frame":
+			stack := session.Stack()
+			n, convErr := strconv.Atoi(strings.Join(args, ""))
+			if convErr != nil || n < 0 || n >= len(stack) {
+				fmt.Fprintln(out, "usage: frame N")
+				continue
+			}
+
+			selected = n
+			fmt.Fprintf(out, "#%d %s (%s)\n", n, stack[n].Function, stack[n].Location)
+			continue
+		case "print", "p":
+			if len(args) != 1 {
+				fmt.Fprintln(out, "usage: print NAME")
+				continue
+			}
+
+			variable, err := session.Lookup(selectedFrame(session, selected), args[0])
+			if err != nil {
+				fmt.Fprintln(out, err)
+				continue
+			}
+			fmt.Fprintf(out, "%s = %s\n", variable.Name, session.Format(variable))
+			continue
+		case "locals", "args", "fields", "statics":
+			kind := map[string]string{"locals": "local", "args": "argument", "fields": "field", "statics": "static"}[command]
+			for _, variable := range session.Variables(selectedFrame(session, selected), kind) {
+				fmt.Fprintf(out, "%s = %s\n", variable.Name, session.Format(variable))
+			}
+			continue
+		case "help", "h":
+			fmt.Fprint(out, help)
+			continue
+		case "quit", "q":
+			return nil
+		default:
+			fmt.Fprintf(out, "unknown command `%s`, try `help`\n", command)
+			continue
+		}
+
+		selected = 0
+		if err != nil {
+			fmt.Fprintf(out, "runtime error at %s: %v\n", session.Location(session.Machine.PC), err)
+			session.Machine.Halted = true
+			continue
+		}
+
+		printStop(session, out, reason)
+	}
+
+	return scanner.Err()
+}
+
+func needsProgram(command string) bool {
+	switch command {
+	case "run", "continue", "c", "step", "s", "next", "n", "finish",
+		"backtrace", "bt", "frame", "print", "p", "locals", "args", "fields", "statics":
+		return true
+	default:
+		return false
+	}
+}
+
+func selectedFrame(session *Session, selected int) Frame {
+	stack := session.Stack()
+	if selected < len(stack) {
+		return stack[selected]
+	}
+
+	return Frame{}
+}
+
+func printStop(session *Session, out io.Writer, reason string) {
+	switch reason {
+	case Exited:
+		fmt.Fprintln(out, "program exited")
+		return
+	case Limit:
+		fmt.Fprintln(out, "step limit reached")
+	case Breakpoint:
+		fmt.Fprint(out, "breakpoint, ")
+	}
+
+	location := session.Location(session.Machine.PC)
+	stack := session.Stack()
+	if len(stack) > 0 {
+		location.Function = stack[0].Function
+	}
+
+	fmt.Fprintf(out, "%s (%s)\n", location.Function, location)
+	if location.Class != nil {
+		fmt.Fprintf(out, "%d\t%s\n", location.Line, strings.TrimSpace(location.Class.Line(location.Line)))
+	}
+}
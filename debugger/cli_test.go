@@ -0,0 +1,109 @@
+package debugger
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/uiureo/jack/project"
+)
+
+func newSession(t *testing.T, path string) *Session {
+	p, err := project.Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	session, err := NewSession(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	session.StepLimit = 100000
+
+	return session
+}
+
+func testScript(t *testing.T, session *Session, script, expected string) {
+	out := &bytes.Buffer{}
+	if err := Run(session, strings.NewReader(script), out); err != nil {
+		t.Fatal(err)
+	}
+
+	if out.String() != expected {
+		t.Errorf("output:\n%s\nwant:\n%s", out.String(), expected)
+	}
+}
+
+func TestRunBreakpointAndInspect(t *testing.T) {
+	testScript(t, newSession(t, "fixtures/Point"), `
+break Point.move
+run
+bt
+args
+fields
+frame 1
+locals
+print p
+print x
+`, `breakpoint at Point.jack:11 in Point.move
+breakpoint, Point.move (Point.jack:11)
+11	let x = x + dx;
+#0 Point.move (Point.jack:11)
+#1 Main.main (Main.jack:9)
+this = 2052
+dx = 3
+x = 1
+y = 2
+#1 Main.main (Main.jack:9)
+p = 2052
+s = "hi"
+p = 2052
+variable `+"`x`"+` is not defined
+`)
+}
+
+func TestRunStepping(t *testing.T) {
+	testScript(t, newSession(t, "fixtures/Point"), `
+break Main.jack:9
+c
+step
+finish
+next
+step
+next
+statics
+c
+step
+`, `breakpoint at Main.jack:9 in Main.main
+breakpoint, Main.main (Main.jack:9)
+9	do p.move(3);
+Point.move (Point.jack:11)
+11	let x = x + dx;
+Main.main (Main.jack:9)
+9	do p.move(3);
+Main.main (Main.jack:10)
+10	let count = p.getX();
+Point.getX (Point.jack:16)
+16	return x;
+Main.main (Main.jack:11)
+11	do Output.printInt(count);
+count = 4
+4program exited
+the program is not running
+`)
+}
+
+func TestSetBreakpointErrors(t *testing.T) {
+	session := newSession(t, "fixtures/Point")
+
+	for spec, message := range map[string]string{
+		"Main.jack:100": "no statement at or after Main.jack:100",
+		"Foo.jack:1":    "file `Foo.jack` not found",
+		"Main.foo":      "subroutine `Main.foo` not found",
+		"Main.jack:x":   "invalid line in `Main.jack:x`",
+	} {
+		if _, err := session.SetBreakpoint(spec); err == nil || err.Error() != message {
+			t.Errorf("%s: expect error `%s`, got %v", spec, message, err)
+		}
+	}
+}
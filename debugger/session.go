@@ -0,0 +1,400 @@
+package debugger
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/uiureo/jack/compiler"
+	"github.com/uiureo/jack/project"
+	"github.com/uiureo/jack/vm"
+)
+
+// Reasons why execution stopped.
+const (
+	Breakpoint = "breakpoint"
+	Step       = "step"
+	Exited     = "exited"
+	Limit      = "limit"
+	Paused     = "pause"
+)
+
+// Location is a position in the Jack source.
+type Location = project.Location
+
+// Frame is a subroutine activation as seen from Jack.
+type Frame struct {
+	Location
+
+	// Index is the position in vm.Machine.Frames.
+	Index int
+}
+
+// Variable is a Jack variable of a frame.
+type Variable struct {
+	Name    string
+	Kind    string // local, argument, field, static or const
+	Type    string
+	Address int // -1 for a const
+	Value   int16
+}
+
+// Session runs a project on the VM emulator, stopping at breakpoints and
+// stepping one Jack statement at a time.
+type Session struct {
+	Project *project.Project
+	Machine *vm.Machine
+
+	// StepLimit stops execution after that many VM instructions per
+	// command when positive.
+	StepLimit int
+
+	// Interrupt stops execution when it is closed, so that another
+	// goroutine can pause a running program.
+	Interrupt <-chan struct{}
+
+	fileStart   []int
+	statements  map[int]bool // pcs that start a statement
+	breakpoints map[int]Location
+}
+
+// NewSession loads the project into a machine stopped before the first
+// instruction.
+func NewSession(p *project.Project) (*Session, error) {
+	program, err := p.Program()
+	if err != nil {
+		return nil, err
+	}
+
+	machine, err := vm.New(program)
+	if err != nil {
+		return nil, err
+	}
+
+	session := &Session{
+		Project:     p,
+		Machine:     machine,
+		statements:  map[int]bool{},
+		breakpoints: map[int]Location{},
+	}
+
+	session.fileStart = make([]int, len(p.Classes))
+	for pc := len(program.Instructions) - 1; pc >= 0; pc-- {
+		instruction := program.Instructions[pc]
+		session.fileStart[instruction.File] = pc - instruction.Index
+	}
+
+	for i, class := range p.Classes {
+		for _, mapping := range class.SourceMap.Mappings {
+			pc := session.fileStart[i] + mapping.Instruction
+			if pc < len(program.Instructions) && program.Instructions[pc].Command != "function" {
+				session.statements[pc] = true
+			}
+		}
+	}
+
+	return session, nil
+}
+
+// Location returns the Jack source position of the instruction at pc.
+func (session *Session) Location(pc int) Location {
+	return session.Project.Location(session.Machine.Program, pc)
+}
+
+// SetBreakpoint sets a breakpoint at `File.jack:line` or at the first
+// statement of a subroutine given as `Class.subroutine`.
+func (session *Session) SetBreakpoint(spec string) (Location, error) {
+	if i := strings.LastIndex(spec, ":"); i >= 0 {
+		line, err := strconv.Atoi(spec[i+1:])
+		if err != nil {
+			return Location{}, fmt.Errorf("invalid line in `%s`", spec)
+		}
+
+		return session.setLineBreakpoint(spec[:i], line)
+	}
+
+	for i, class := range session.Project.Classes {
+		for _, subroutine := range class.SourceMap.Subroutines {
+			if subroutine.Name != spec {
+				continue
+			}
+
+			for pc := session.fileStart[i] + subroutine.Start; pc < session.fileStart[i]+subroutine.End; pc++ {
+				if session.statements[pc] {
+					return session.setBreakpointAt(pc), nil
+				}
+			}
+		}
+	}
+
+	return Location{}, fmt.Errorf("subroutine `%s` not found", spec)
+}
+
+func (session *Session) setLineBreakpoint(file string, line int) (Location, error) {
+	for i, class := range session.Project.Classes {
+		if filepath.Base(class.JackFile) != file && class.Name != file {
+			continue
+		}
+
+		// the first statement on the line, or on the nearest line after it
+		best := -1
+		for _, mapping := range class.SourceMap.Mappings {
+			pc := session.fileStart[i] + mapping.Instruction
+			if !session.statements[pc] || mapping.Line < line {
+				continue
+			}
+
+			if best < 0 || mapping.Line < session.Location(best).Line {
+				best = pc
+			}
+		}
+
+		if best < 0 {
+			return Location{}, fmt.Errorf("no statement at or after %s:%d", file, line)
+		}
+
+		return session.setBreakpointAt(best), nil
+	}
+
+	return Location{}, fmt.Errorf("file `%s` not found", file)
+}
+
+func (session *Session) setBreakpointAt(pc int) Location {
+	location := session.Location(pc)
+	session.breakpoints[pc] = location
+
+	return location
+}
+
+// ClearBreakpoints removes the breakpoints in a Jack file, or all
+// breakpoints if file is empty.
+func (session *Session) ClearBreakpoints(file string) {
+	for pc, location := range session.breakpoints {
+		if file == "" || filepath.Base(location.Class.JackFile) == filepath.Base(file) {
+			delete(session.breakpoints, pc)
+		}
+	}
+}
+
+// Continue runs until a breakpoint is hit or the program halts.
+func (session *Session) Continue() (string, error) {
+	return session.run(func() bool { return false })
+}
+
+// StepIn runs to the next statement, entering called subroutines.
+func (session *Session) StepIn() (string, error) {
+	return session.run(func() bool {
+		return session.statements[session.Machine.PC]
+	})
+}
+
+// StepOver runs to the next statement of the current subroutine or of its
+// callers.
+func (session *Session) StepOver() (string, error) {
+	depth := len(session.Machine.Frames)
+
+	return session.run(func() bool {
+		return len(session.Machine.Frames) <= depth && session.statements[session.Machine.PC]
+	})
+}
+
+// StepOut runs until the current subroutine returns to its caller.
+func (session *Session) StepOut() (string, error) {
+	depth := len(session.Machine.Frames)
+
+	return session.run(func() bool {
+		return len(session.Machine.Frames) < depth
+	})
+}
+
+func (session *Session) run(stop func() bool) (string, error) {
+	machine := session.Machine
+
+	for steps := 0; !machine.Halted; steps++ {
+		if session.StepLimit > 0 && steps >= session.StepLimit {
+			return Limit, nil
+		}
+
+		select {
+		case <-session.Interrupt:
+			return Paused, nil
+		default:
+		}
+
+		if err := machine.Step(); err != nil {
+			return "", err
+		}
+
+		if machine.Halted {
+			break
+		}
+
+		if _, ok := session.breakpoints[machine.PC]; ok {
+			return Breakpoint, nil
+		}
+
+		if stop() {
+			return Step, nil
+		}
+	}
+
+	return Exited, nil
+}
+
+// Stack returns the call stack, innermost frame first.
+func (session *Session) Stack() []Frame {
+	frames := session.Machine.Frames
+	stack := make([]Frame, 0, len(frames))
+
+	for i := len(frames) - 1; i >= 0; i-- {
+		pc := session.Machine.PC
+		if i < len(frames)-1 {
+			pc = frames[i+1].CallPC
+		}
+
+		location := session.Location(pc)
+		location.Function = frames[i].Function
+
+		stack = append(stack, Frame{Location: location, Index: i})
+	}
+
+	return stack
+}
+
+// Variables returns the variables of a kind (local, argument, field or
+// static) visible in the frame, ordered by their index in the segment.
+func (session *Session) Variables(frame Frame, kind string) []Variable {
+	table := session.table(frame)
+	if table == nil {
+		return []Variable{}
+	}
+
+	scope := table.Scopes[0]
+	if kind == "field" || kind == "static" {
+		scope = table.Scopes[len(table.Scopes)-1]
+	}
+
+	variables := []Variable{}
+	for name, symbol := range scope {
+		if symbol.Kind != kind || (kind == "field" && !session.hasThis(frame)) {
+			continue
+		}
+
+		variables = append(variables, session.variable(frame, name, symbol))
+	}
+
+	sort.Slice(variables, func(i, j int) bool {
+		return variables[i].Address < variables[j].Address
+	})
+
+	return variables
+}
+
+// Lookup returns the variable with a Jack name as seen from the frame.
+func (session *Session) Lookup(frame Frame, name string) (Variable, error) {
+	table := session.table(frame)
+	if table == nil {
+		return Variable{}, fmt.Errorf("no symbols for `%s`", frame.Function)
+	}
+
+	symbol := table.Get(name)
+	if symbol == nil || symbol.Kind == "class" {
+		return Variable{}, fmt.Errorf("variable `%s` is not defined", name)
+	}
+
+	if symbol.Kind == "field" && !session.hasThis(frame) {
+		return Variable{}, fmt.Errorf("field `%s` is not accessible in function `%s`", name, frame.Function)
+	}
+
+	return session.variable(frame, name, symbol), nil
+}
+
+func (session *Session) table(frame Frame) *compiler.SymbolTable {
+	if frame.Class == nil {
+		return nil
+	}
+
+	return frame.Class.Tables[frame.Function]
+}
+
+func (session *Session) hasThis(frame Frame) bool {
+	subroutine := frame.Class.Subroutine(frame.Function)
+
+	return subroutine != nil && subroutine.Children[0].Value != "function"
+}
+
+func (session *Session) variable(frame Frame, name string, symbol *compiler.Symbol) Variable {
+	machine := session.Machine
+	vmFrame := machine.Frames[frame.Index]
+
+	var address int
+	switch symbol.Kind {
+	case "local":
+		address = vmFrame.LCL + symbol.Number
+	case "argument":
+		address = vmFrame.ARG + symbol.Number
+	case "field":
+		address = int(session.this(frame)) + symbol.Number
+	case "static":
+		address = machine.Program.StaticBase[session.fileIndex(frame.Class)] + symbol.Number
+	}
+
+	if symbol.Kind == "const" {
+		return Variable{Name: name, Kind: symbol.Kind, Type: symbol.SymbolType, Address: -1, Value: int16(symbol.Number)}
+	}
+
+	variable := Variable{Name: name, Kind: symbol.Kind, Type: symbol.SymbolType, Address: address}
+	if 0 <= address && address < len(machine.RAM) {
+		variable.Value = machine.RAM[address]
+	}
+
+	return variable
+}
+
+// this returns the value of `this` in the frame. The callee of a frame
+// saves it in its own frame.
+func (session *Session) this(frame Frame) int16 {
+	frames := session.Machine.Frames
+	if frame.Index == len(frames)-1 {
+		return session.Machine.RAM[vm.THIS]
+	}
+
+	return session.Machine.RAM[frames[frame.Index+1].LCL-2]
+}
+
+func (session *Session) fileIndex(class *project.Class) int {
+	for i, c := range session.Project.Classes {
+		if c == class {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// Format renders the value of a variable according to its Jack type.
+func (session *Session) Format(variable Variable) string {
+	switch variable.Type {
+	case "boolean":
+		switch variable.Value {
+		case 0:
+			return "false"
+		case -1:
+			return "true"
+		}
+	case "char":
+		if 32 <= variable.Value && variable.Value < 127 {
+			return fmt.Sprintf("'%c'", variable.Value)
+		}
+	case "String":
+		if variable.Value != 0 {
+			if s, err := session.Machine.ReadString(variable.Value); err == nil {
+				return strconv.Quote(s)
+			}
+		}
+	}
+
+	return strconv.Itoa(int(variable.Value))
+}
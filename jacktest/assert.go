@@ -0,0 +1,61 @@
+package jacktest
+
+import (
+	"fmt"
+
+	"github.com/uiureo/jack/vm"
+)
+
+// assertions implements the Assert class. A failing assertion sets failed
+// and stops the test with an error.
+func assertions(failed *bool) map[string]vm.Builtin {
+	fail := func(format string, args ...interface{}) error {
+		*failed = true
+		return fmt.Errorf(format, args...)
+	}
+
+	return map[string]vm.Builtin{
+		// Assert.equals(int expected, int actual)
+		"Assert.equals": func(machine *vm.Machine, args []int16) (int16, error) {
+			if len(args) != 2 {
+				return 0, fmt.Errorf("Assert.equals: expecting 2 arguments, got %d", len(args))
+			}
+
+			if args[0] != args[1] {
+				return 0, fail("expected %d, got %d", args[0], args[1])
+			}
+
+			return 0, nil
+		},
+
+		// Assert.isTrue(boolean condition)
+		"Assert.isTrue": func(machine *vm.Machine, args []int16) (int16, error) {
+			if len(args) != 1 {
+				return 0, fmt.Errorf("Assert.isTrue: expecting 1 argument, got %d", len(args))
+			}
+
+			if args[0] == 0 {
+				return 0, fail("expected true, got false")
+			}
+
+			return 0, nil
+		},
+
+		// Assert.fail() or Assert.fail(String message)
+		"Assert.fail": func(machine *vm.Machine, args []int16) (int16, error) {
+			switch len(args) {
+			case 0:
+				return 0, fail("failed")
+			case 1:
+				message, err := machine.ReadString(args[0])
+				if err != nil {
+					return 0, err
+				}
+
+				return 0, fail("%s", message)
+			default:
+				return 0, fmt.Errorf("Assert.fail: expecting at most 1 argument, got %d", len(args))
+			}
+		},
+	}
+}
@@ -0,0 +1,149 @@
+// Package jacktest runs unit tests written in Jack: the `function void
+// testX()` subroutines of classes named `*Test`, which check results with
+// the Assert class.
+package jacktest
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/uiureo/jack/parser"
+	"github.com/uiureo/jack/project"
+	"github.com/uiureo/jack/vm"
+)
+
+// DefaultMaxSteps bounds the run of a test when Runner.MaxSteps is zero.
+const DefaultMaxSteps = 10000000
+
+// Result is the outcome of a test.
+type Result struct {
+	Test   string // VM function name, e.g. `MathTest.testAdd`
+	Passed bool
+
+	// Message describes the failure, and Location is where the failing
+	// assertion or the runtime error occurred.
+	Message  string
+	Location project.Location
+
+	// Output is what the test printed.
+	Output string
+}
+
+// Tests returns the test functions of the project in the order of their
+// declaration.
+func Tests(p *project.Project) []string {
+	tests := []string{}
+
+	for _, class := range p.Classes {
+		if !strings.HasSuffix(class.Name, "Test") {
+			continue
+		}
+
+		for _, node := range class.Tree.Children {
+			if node.Name != "subroutineDec" {
+				continue
+			}
+
+			kind, returnType, name := node.Children[0].Value, node.Children[1].Value, node.Children[2].Value
+			parameters, _ := node.Find(&parser.Node{Name: "parameterList"})
+			if kind == "function" && returnType == "void" && strings.HasPrefix(name, "test") && len(parameters.Children) == 0 {
+				tests = append(tests, class.Name+"."+name)
+			}
+		}
+	}
+
+	return tests
+}
+
+// Runner runs tests of a project, each on a fresh machine.
+type Runner struct {
+	Project *project.Project
+	Program *vm.Program
+
+	// MaxSteps fails a test that runs more VM instructions.
+	MaxSteps int
+}
+
+// NewRunner links the project for running its tests.
+func NewRunner(p *project.Project) (*Runner, error) {
+	program, err := p.Program()
+	if err != nil {
+		return nil, err
+	}
+
+	return &Runner{Project: p, Program: program}, nil
+}
+
+// Run runs a single test function.
+func (runner *Runner) Run(test string) *Result {
+	result := &Result{Test: test}
+
+	machine, err := vm.NewCall(runner.Program, test)
+	if err != nil {
+		result.Message = err.Error()
+		return result
+	}
+
+	output := &bytes.Buffer{}
+	machine.Output = output
+
+	failed := false
+	for name, builtin := range assertions(&failed) {
+		machine.Builtins[name] = builtin
+	}
+
+	maxSteps := runner.MaxSteps
+	if maxSteps == 0 {
+		maxSteps = DefaultMaxSteps
+	}
+
+	err = machine.Run(maxSteps)
+	result.Output = output.String()
+
+	switch err {
+	case nil:
+		result.Passed = true
+	case vm.ErrStepLimit:
+		result.Message = fmt.Sprintf("no result after %d steps", maxSteps)
+		result.Location = runner.Project.Location(runner.Program, machine.PC)
+	default:
+		result.Message = err.Error()
+		if !failed {
+			result.Message = "runtime error: " + result.Message
+		}
+		result.Location = runner.Project.Location(runner.Program, machine.PC)
+	}
+
+	return result
+}
+
+// RunAll runs every test of the project, reporting to out, and returns the
+// number of failed tests.
+func (runner *Runner) RunAll(out io.Writer) int {
+	tests := Tests(runner.Project)
+	failed := 0
+
+	for _, test := range tests {
+		result := runner.Run(test)
+		if result.Passed {
+			fmt.Fprintf(out, "PASS %s\n", test)
+			continue
+		}
+
+		failed++
+		fmt.Fprintf(out, "FAIL %s (%s)\n", test, result.Location)
+		fmt.Fprintf(out, "    %s\n", result.Message)
+		if class := result.Location.Class; class != nil {
+			fmt.Fprintf(out, "    %d\t%s\n", result.Location.Line, strings.TrimSpace(class.Line(result.Location.Line)))
+		}
+		if result.Output != "" {
+			fmt.Fprintf(out, "    output: %q\n", result.Output)
+		}
+	}
+
+	fmt.Fprintf(out, "%d passed, %d failed\n", len(tests)-failed, failed)
+
+	return failed
+}
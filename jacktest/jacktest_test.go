@@ -0,0 +1,89 @@
+package jacktest
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"github.com/uiureo/jack/project"
+)
+
+func newRunner(t *testing.T) *Runner {
+	p, err := project.Load("fixtures/Counter")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	runner, err := NewRunner(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return runner
+}
+
+func TestTests(t *testing.T) {
+	expected := []string{
+		"CounterTest.testIncrement",
+		"CounterTest.testFreshProgram",
+		"CounterTest.testEquals",
+		"CounterTest.testIsTrue",
+		"CounterTest.testFail",
+		"CounterTest.testRuntimeError",
+	}
+
+	if tests := Tests(newRunner(t).Project); !reflect.DeepEqual(tests, expected) {
+		t.Errorf("expect %v, got %v", expected, tests)
+	}
+}
+
+func TestRun(t *testing.T) {
+	runner := newRunner(t)
+
+	tests := []struct {
+		test, message string
+		line          int
+	}{
+		{"CounterTest.testIncrement", "", 0},
+		{"CounterTest.testFreshProgram", "", 0},
+		{"CounterTest.testEquals", "expected 2, got 1", 27},
+		{"CounterTest.testIsTrue", "expected true, got false", 33},
+		{"CounterTest.testFail", "not implemented", 38},
+		{"CounterTest.testRuntimeError", "runtime error: Math.divide: division by zero (Sys.error 3)", 45},
+	}
+
+	for _, test := range tests {
+		result := runner.Run(test.test)
+
+		if result.Passed != (test.message == "") || result.Message != test.message || result.Location.Line != test.line {
+			t.Errorf("%s: expect `%s` at line %d, got `%s` at line %d", test.test, test.message, test.line, result.Message, result.Location.Line)
+		}
+	}
+}
+
+func TestRunAll(t *testing.T) {
+	out := &bytes.Buffer{}
+	failed := newRunner(t).RunAll(out)
+
+	expected := `PASS CounterTest.testIncrement
+PASS CounterTest.testFreshProgram
+FAIL CounterTest.testEquals (CounterTest.jack:27)
+    expected 2, got 1
+    27	do Assert.equals(2, counter.count());
+FAIL CounterTest.testIsTrue (CounterTest.jack:33)
+    expected true, got false
+    33	do Assert.isTrue(1 > 2);
+    output: "checking"
+FAIL CounterTest.testFail (CounterTest.jack:38)
+    not implemented
+    38	do Assert.fail("not implemented");
+FAIL CounterTest.testRuntimeError (CounterTest.jack:45)
+    runtime error: Math.divide: division by zero (Sys.error 3)
+    45	do Assert.equals(0, 1 / zero);
+2 passed, 4 failed
+`
+
+	if failed != 4 || out.String() != expected {
+		t.Errorf("expect 4 failures and:\n%s\ngot %d and:\n%s", expected, failed, out.String())
+	}
+}
@@ -1,40 +1,414 @@
 package main
 
 import (
+	"encoding/json"
+	"flag"
 	"fmt"
 	"io/ioutil"
 	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
 
 	"github.com/uiureo/jack/compiler"
+	"github.com/uiureo/jack/dap"
+	"github.com/uiureo/jack/debugger"
+	"github.com/uiureo/jack/jacktest"
+	"github.com/uiureo/jack/memcheck"
 	"github.com/uiureo/jack/parser"
+	"github.com/uiureo/jack/profile"
+	"github.com/uiureo/jack/project"
+	"github.com/uiureo/jack/stats"
 	"github.com/uiureo/jack/tokenizer"
+	"github.com/uiureo/jack/vm"
 )
 
+const extUsage = "accept the Jack language extensions described in the readme"
+
 func main() {
 	if len(os.Args) < 2 {
 		fmt.Fprintln(os.Stderr, "no files given")
 		os.Exit(1)
 	}
 
-	parseMode := os.Args[1] == "parse"
+	switch os.Args[1] {
+	case "parse":
+		runParse(os.Args[2:])
+	case "tokens":
+		if len(os.Args) < 3 {
+			fmt.Fprintln(os.Stderr, "no files given")
+			os.Exit(1)
+		}
+
+		fmt.Print(tokenizer.ToXML(tokenizer.Tokenize(readFile(os.Args[2]))))
+	case "debug":
+		runDebug(os.Args[2:])
+	case "run":
+		runRun(os.Args[2:])
+	case "test":
+		runTest(os.Args[2:])
+	case "profile":
+		runProfile(os.Args[2:])
+	case "stats":
+		runStats(os.Args[2:])
+	case "runtime":
+		fmt.Print(compiler.RuntimeSource)
+	case "dap":
+		if err := dap.Serve(os.Stdin, os.Stdout); err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+			os.Exit(1)
+		}
+	default:
+		runCompile(os.Args[1:])
+	}
+}
+
+func runParse(args []string) {
+	flags := flag.NewFlagSet("jack parse", flag.ExitOnError)
+	format := flags.String("format", "xml", "output format: xml, json, sexp or tokens")
+	ext := flags.Bool("ext", false, extUsage)
+	flags.Parse(args)
+	project.Extensions = *ext
+
+	if flags.NArg() < 1 {
+		fmt.Fprintln(os.Stderr, "no files given")
+		os.Exit(1)
+	}
+
+	filename := flags.Arg(0)
+
+	switch *format {
+	case "xml":
+		fmt.Print(parseFile(filename).ToXML())
+	case "json":
+		fmt.Print(parseFile(filename).ToJSON())
+	case "sexp":
+		fmt.Print(parseFile(filename).ToSexp())
+	case "tokens":
+		for _, token := range tokenize(readFile(filename)) {
+			fmt.Printf("%d:%d\t%s\t%s\n", token.Line, token.Column, token.TokenType, strconv.Quote(token.Value))
+		}
+	default:
+		fmt.Fprintf(os.Stderr, "unknown format `%s`\n", *format)
+		os.Exit(1)
+	}
+}
+
+func runCompile(args []string) {
+	flags := flag.NewFlagSet("jack", flag.ExitOnError)
+	sourceMap := flags.Bool("source-map", false, "write a source map next to the Jack file (Main.vm.map)")
+	sourceComments := flags.Bool("source-comments", false, "precede the code of each statement with a `// Main.jack:12` comment")
+	checked := flags.Bool("checked", false, "check array bounds and null pointers at run time, on the VM emulator only")
+	ext := flags.Bool("ext", false, extUsage)
+	flags.Parse(args)
+	project.Extensions = *ext
+	compiler.Checked = *checked
+
+	if flags.NArg() < 1 {
+		fmt.Fprintln(os.Stderr, "no files given")
+		os.Exit(1)
+	}
+
+	filename := flags.Arg(0)
+	tree := parseFile(filename)
+	if project.Extensions {
+		if err := declareClasses(filename); err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+			os.Exit(1)
+		}
+	}
+
+	if errs := compiler.Check(tree); len(errs) > 0 {
+		for _, err := range errs {
+			fmt.Fprintf(os.Stderr, "%s:%s\n", filename, err)
+		}
+		os.Exit(1)
+	}
+
+	code, vmSourceMap := compiler.CompileWithSourceMap(tree, filename, *sourceComments)
+
+	if *sourceMap {
+		data, _ := json.MarshalIndent(vmSourceMap, "", "  ")
+		mapFile := strings.TrimSuffix(filename, ".jack") + ".vm.map"
+
+		if err := ioutil.WriteFile(mapFile, append(data, '\n'), 0644); err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+			os.Exit(1)
+		}
+	}
+
+	fmt.Print(code)
+}
+
+func runDebug(args []string) {
+	flags := flag.NewFlagSet("jack debug", flag.ExitOnError)
+	input := flags.String("input", "", "keyboard input for the program; \\n is the newline key")
+	checked := flags.Bool("checked", false, "check array bounds and null pointers at run time, on the VM emulator only")
+	ext := flags.Bool("ext", false, extUsage)
+	flags.Parse(args)
+	project.Extensions = *ext
+	compiler.Checked = *checked
+
+	if flags.NArg() < 1 {
+		fmt.Fprintln(os.Stderr, "no files given")
+		os.Exit(1)
+	}
+
+	p, err := project.Load(flags.Arg(0))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+
+	session, err := debugger.NewSession(p)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+	session.Machine.Keyboard = vm.KeyboardInput(strings.Replace(*input, `\n`, "\n", -1))
+
+	if err := debugger.Run(session, os.Stdin, os.Stdout); err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+}
+
+func runRun(args []string) {
+	flags := flag.NewFlagSet("jack run", flag.ExitOnError)
+	input := flags.String("input", "", "keyboard input for the program; \\n is the newline key")
+	maxSteps := flags.Int("steps", 0, "stop the program after that many VM instructions")
+	checkHeap := flags.Bool("check-heap", false, "report heap leaks, double deAlloc and use after free at exit")
+	checked := flags.Bool("checked", false, "check array bounds and null pointers at run time, on the VM emulator only")
+	ext := flags.Bool("ext", false, extUsage)
+	flags.Parse(args)
+	project.Extensions = *ext
+	compiler.Checked = *checked
+
+	if flags.NArg() < 1 {
+		fmt.Fprintln(os.Stderr, "no files given")
+		os.Exit(1)
+	}
+
+	p, err := project.Load(flags.Arg(0))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+
+	program, err := p.Program()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(1)
+	}
 
-	filename := os.Args[1]
-	if parseMode {
-		filename = os.Args[2]
+	machine, err := vm.New(program)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+	machine.Output = os.Stdout
+	machine.Keyboard = vm.KeyboardInput(strings.Replace(*input, `\n`, "\n", -1))
+	if *checkHeap {
+		machine.CheckHeap()
 	}
 
+	status := 0
+	if err := machine.Run(*maxSteps); err != nil {
+		fmt.Fprintf(os.Stderr, "\n%s: %v\n", p.Location(program, machine.PC), err)
+		status = 1
+	}
+
+	if *checkHeap && memcheck.Report(p, machine, os.Stderr) > 0 {
+		status = 1
+	}
+
+	os.Exit(status)
+}
+
+func runTest(args []string) {
+	flags := flag.NewFlagSet("jack test", flag.ExitOnError)
+	maxSteps := flags.Int("steps", jacktest.DefaultMaxSteps, "fail a test after that many VM instructions")
+	checked := flags.Bool("checked", false, "check array bounds and null pointers at run time, on the VM emulator only")
+	ext := flags.Bool("ext", false, extUsage)
+	flags.Parse(args)
+	project.Extensions = *ext
+	compiler.Checked = *checked
+
+	if flags.NArg() < 1 {
+		fmt.Fprintln(os.Stderr, "no files given")
+		os.Exit(1)
+	}
+
+	p, err := project.Load(flags.Arg(0))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+
+	runner, err := jacktest.NewRunner(p)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+	runner.MaxSteps = *maxSteps
+
+	if runner.RunAll(os.Stdout) > 0 {
+		os.Exit(1)
+	}
+}
+
+func runProfile(args []string) {
+	flags := flag.NewFlagSet("jack profile", flag.ExitOnError)
+	input := flags.String("input", "", "keyboard input for the program; \\n is the newline key")
+	maxSteps := flags.Int("steps", 0, "stop the program after that many VM instructions")
+	lines := flags.Int("lines", 10, "number of hot lines to list")
+	pprof := flags.String("pprof", "", "write the profile to `file` in the pprof format")
+	checked := flags.Bool("checked", false, "check array bounds and null pointers at run time, on the VM emulator only")
+	ext := flags.Bool("ext", false, extUsage)
+	flags.Parse(args)
+	project.Extensions = *ext
+	compiler.Checked = *checked
+
+	if flags.NArg() < 1 {
+		fmt.Fprintln(os.Stderr, "no files given")
+		os.Exit(1)
+	}
+
+	p, err := project.Load(flags.Arg(0))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+
+	program, err := p.Program()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+
+	machine, err := vm.New(program)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+	// the output of the program goes to stderr to keep the report apart
+	machine.Output = os.Stderr
+	machine.Keyboard = vm.KeyboardInput(strings.Replace(*input, `\n`, "\n", -1))
+
+	status := 0
+	result, err := profile.Run(p, machine, *maxSteps)
+	if err != nil && err != vm.ErrStepLimit {
+		fmt.Fprintf(os.Stderr, "\n%s: %v\n", p.Location(program, machine.PC), err)
+		status = 1
+	}
+
+	result.WriteText(os.Stdout, *lines)
+
+	if *pprof != "" {
+		file, err := os.Create(*pprof)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+			os.Exit(1)
+		}
+
+		err = result.WritePprof(file)
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+			os.Exit(1)
+		}
+	}
+
+	os.Exit(status)
+}
+
+func runStats(args []string) {
+	flags := flag.NewFlagSet("jack stats", flag.ExitOnError)
+	ext := flags.Bool("ext", false, extUsage)
+	flags.Parse(args)
+	project.Extensions = *ext
+
+	if flags.NArg() < 1 {
+		fmt.Fprintln(os.Stderr, "no files given")
+		os.Exit(1)
+	}
+
+	p, err := project.Load(flags.Arg(0))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+
+	result, err := stats.Count(p)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+
+	result.WriteText(os.Stdout)
+}
+
+// declareClasses declares the classes next to the Jack file, for inherited
+// members and `Class.CONST` terms. Like project.Load, it fails on a class
+// that doesn't parse.
+func declareClasses(filename string) error {
+	jackFiles, _ := filepath.Glob(filepath.Join(filepath.Dir(filename), "*.jack"))
+
+	nodes := []*parser.Node{}
+	for _, jackFile := range jackFiles {
+		node, err := parseSibling(jackFile)
+		if err != nil {
+			return err
+		}
+		if node == nil {
+			return fmt.Errorf("%s: expecting class declaration", jackFile)
+		}
+
+		nodes = append(nodes, node)
+	}
+
+	compiler.DeclareClasses(nodes)
+
+	return nil
+}
+
+// parseSibling parses a class of declareClasses, returning the syntax error
+// that parseFile panics with.
+func parseSibling(jackFile string) (node *parser.Node, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%s: %v", jackFile, r)
+		}
+	}()
+
+	return parseFile(jackFile), nil
+}
+
+func tokenize(source string) []*tokenizer.Token {
+	if project.Extensions {
+		return tokenizer.TokenizeExt(source)
+	}
+
+	return tokenizer.Tokenize(source)
+}
+
+func readFile(filename string) string {
 	data, err := ioutil.ReadFile(filename)
 	if err != nil {
 		fmt.Fprintln(os.Stderr, err.Error())
 		os.Exit(1)
 	}
 
-	tokens := tokenizer.Tokenize(string(data))
-	tree := parser.Parse(tokens)
+	return string(data)
+}
 
-	if parseMode {
-		fmt.Print(tree.ToXML())
-	} else {
-		fmt.Print(compiler.Compile(tree))
+func parseFile(filename string) *parser.Node {
+	tokens := tokenize(readFile(filename))
+	if project.Extensions {
+		return parser.ParseExt(tokens)
 	}
+
+	return parser.Parse(tokens)
 }
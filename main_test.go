@@ -2,18 +2,18 @@ package main
 
 import (
 	"io/ioutil"
-	"os/exec"
+	"os"
 	"path/filepath"
 	"regexp"
 	"strings"
 	"testing"
 
 	"github.com/uiureo/jack/parser"
+	"github.com/uiureo/jack/project"
+	"github.com/uiureo/jack/textcmp"
 	"github.com/uiureo/jack/tokenizer"
 )
 
-const toolPath = "/Users/zat/Downloads/nand2tetris/tools/TextComparer.sh"
-
 func TestMain(t *testing.T) {
 	files, err := filepath.Glob("fixtures/*.jack")
 	if err != nil {
@@ -38,12 +38,61 @@ func testMainOutput(t *testing.T, jackFile string) {
 
 	parserOutput := parser.Parse(tokenizer.Tokenize(string(code))).ToXML()
 
-	file, _ := ioutil.TempFile("", "")
-	file.Write([]byte(parserOutput))
+	compareWithFile(t, name, parserOutput, xmlFile)
+}
+
+// TestTokens compares the tokens with the TokenizerTest files of nand2tetris
+// project 10, which have CRLF line endings, ignoring whitespace.
+func TestTokens(t *testing.T) {
+	files, err := filepath.Glob("fixtures/*.jack")
+	if err != nil {
+		panic(err)
+	}
+
+	for _, jackFile := range files {
+		testTokensOutput(t, jackFile)
+	}
+}
+
+func testTokensOutput(t *testing.T, jackFile string) {
+	xmlFile := regexp.MustCompile(`\.jack$`).ReplaceAllString(jackFile, "T.xml")
 
-	output, err := exec.Command(toolPath, file.Name(), xmlFile).CombinedOutput()
+	name := strings.Split(filepath.Base(jackFile), ".")[0]
 
+	code, err := ioutil.ReadFile(jackFile)
 	if err != nil {
-		t.Errorf("%s: %s %v", name, output, err)
+		t.Error(err)
+		return
+	}
+
+	compareWithFile(t, name, tokenizer.ToXML(tokenizer.Tokenize(string(code))), xmlFile)
+}
+
+func compareWithFile(t *testing.T, name, actual, expectedFile string) {
+	expected, err := ioutil.ReadFile(expectedFile)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if diff := textcmp.Diff(name, expectedFile, actual, string(expected)); diff != "" {
+		t.Errorf("%s: output differs from %s\n%s", name, expectedFile, diff)
+	}
+}
+
+func TestDeclareClassesReportsSyntaxErrors(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "jack")
+	defer os.RemoveAll(dir)
+
+	mainFile := filepath.Join(dir, "Main.jack")
+	ioutil.WriteFile(mainFile, []byte("class Main {\n  function int main() {\n    return Other.Q;\n  }\n}\n"), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "Other.jack"), []byte("class Other {\n  const int Q = ;\n}\n"), 0644)
+
+	project.Extensions = true
+	defer func() { project.Extensions = false }()
+
+	err := declareClasses(mainFile)
+	if err == nil || !strings.HasPrefix(err.Error(), filepath.Join(dir, "Other.jack")+": ") {
+		t.Errorf("expect the syntax error of Other.jack, got %v", err)
 	}
 }
@@ -0,0 +1,77 @@
+// Package memcheck reports the heap issues found by the heap check of the VM
+// emulator in terms of the Jack source.
+package memcheck
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/uiureo/jack/project"
+	"github.com/uiureo/jack/vm"
+)
+
+// Report writes the heap issues of a machine that ran a program of p and
+// returns their number. Leaks allocated at the same call site are reported
+// together. The vtables of classes, which live until the program ends, aren't
+// leaks.
+func Report(p *project.Project, machine *vm.Machine, w io.Writer) int {
+	site := func(pc int, stack []int) string {
+		location := p.Location(machine.Program, pc)
+		result := fmt.Sprintf("%s (%s)", location.Function, location)
+
+		if len(stack) > 0 {
+			caller := p.Location(machine.Program, stack[0])
+			result += fmt.Sprintf(", called from %s (%s)", caller.Function, caller)
+		}
+
+		return result
+	}
+
+	type leak struct {
+		site          string
+		blocks, words int
+	}
+	leaks := []*leak{}
+	leaksBySite := map[string]*leak{}
+
+	count := 0
+	for _, issue := range machine.HeapIssues() {
+		if issue.Kind == vm.Leak && strings.HasSuffix(p.Location(machine.Program, issue.Allocation.PC).Function, ".$vtable") {
+			continue
+		}
+		count++
+
+		switch issue.Kind {
+		case vm.Leak:
+			allocated := site(issue.Allocation.PC, issue.Allocation.Stack)
+			if leaksBySite[allocated] == nil {
+				leaksBySite[allocated] = &leak{site: allocated}
+				leaks = append(leaks, leaksBySite[allocated])
+			}
+			leaksBySite[allocated].blocks++
+			leaksBySite[allocated].words += issue.Allocation.Size
+		case vm.UseAfterFree:
+			access := "read of"
+			if instruction := machine.Program.Instructions[issue.PC]; instruction.Command == "pop" {
+				access = "write to"
+			}
+
+			fmt.Fprintf(w, "use after free: %s %d in %s\n", access, issue.Address, site(issue.PC, issue.Stack))
+			fmt.Fprintf(w, "    block %d allocated in %s\n", issue.Allocation.Base, site(issue.Allocation.PC, issue.Allocation.Stack))
+			fmt.Fprintf(w, "    freed in %s\n", site(issue.Allocation.FreedPC, nil))
+		case vm.DoubleDeAlloc:
+			fmt.Fprintf(w, "double deAlloc: block %d in %s\n", issue.Address, site(issue.PC, issue.Stack))
+			fmt.Fprintf(w, "    allocated in %s\n", site(issue.Allocation.PC, issue.Allocation.Stack))
+			fmt.Fprintf(w, "    freed in %s\n", site(issue.Allocation.FreedPC, nil))
+		default:
+			fmt.Fprintf(w, "%s: %d is not an allocated block, in %s\n", issue.Kind, issue.Address, site(issue.PC, issue.Stack))
+		}
+	}
+
+	for _, leak := range leaks {
+		fmt.Fprintf(w, "leak: %d blocks (%d words) allocated in %s\n", leak.blocks, leak.words, leak.site)
+	}
+
+	return count
+}
@@ -0,0 +1,105 @@
+package memcheck
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/uiureo/jack/project"
+	"github.com/uiureo/jack/vm"
+)
+
+func TestReport(t *testing.T) {
+	p, err := project.Load("fixtures/Misuse")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	program, err := p.Program()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	machine, err := vm.New(program)
+	if err != nil {
+		t.Fatal(err)
+	}
+	machine.CheckHeap()
+
+	if err := machine.Run(100000); err != nil {
+		t.Fatal(err)
+	}
+
+	out := &bytes.Buffer{}
+	count := Report(p, machine, out)
+
+	expected := `use after free: read of 2048 in Node.value (Node.jack:11), called from Main.main (Main.jack:8)
+    block 2048 allocated in Node.new (Node.jack:5), called from Main.main (Main.jack:6)
+    freed in Node.dispose (Node.jack:15)
+double deAlloc: block 2048 in Node.dispose (Node.jack:15), called from Main.main (Main.jack:9)
+    allocated in Node.new (Node.jack:5), called from Main.main (Main.jack:6)
+    freed in Node.dispose (Node.jack:15)
+invalid deAlloc: 3000 is not an allocated block, in Main.main (Main.jack:17)
+leak: 3 blocks (6 words) allocated in Node.new (Node.jack:5), called from Main.main (Main.jack:13)
+`
+
+	if count != 6 || out.String() != expected {
+		t.Errorf("expect 6 issues:\n%s\ngot %d:\n%s", expected, count, out.String())
+	}
+}
+
+func TestVtablesAreNotLeaks(t *testing.T) {
+	project.Extensions = true
+	defer func() { project.Extensions = false }()
+
+	p, err := project.Load("fixtures/Vtable")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	program, err := p.Program()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	machine, err := vm.New(program)
+	if err != nil {
+		t.Fatal(err)
+	}
+	machine.CheckHeap()
+
+	if err := machine.Run(100000); err != nil {
+		t.Fatal(err)
+	}
+
+	out := &bytes.Buffer{}
+	if count := Report(p, machine, out); count != 0 {
+		t.Errorf("expect no issues, got %d:\n%s", count, out.String())
+	}
+}
+
+func TestNoIssues(t *testing.T) {
+	program, err := vm.NewProgram([]vm.File{{Name: "Main.vm", Code: `
+function Main.main 0
+push constant 2
+call Array.new 1
+call Array.dispose 1
+return
+`}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	machine, err := vm.New(program)
+	if err != nil {
+		t.Fatal(err)
+	}
+	machine.CheckHeap()
+
+	if err := machine.Run(1000); err != nil {
+		t.Fatal(err)
+	}
+
+	if issues := machine.HeapIssues(); len(issues) != 0 {
+		t.Errorf("expect no issues, got %v", issues[0].Kind)
+	}
+}
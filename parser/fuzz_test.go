@@ -0,0 +1,78 @@
+package parser
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/uiureo/jack/tokenizer"
+)
+
+func addSeeds(f *testing.F) {
+	for _, pattern := range []string{"../fixtures/*.jack", "../compiler/fixtures/*/*.jack"} {
+		files, _ := filepath.Glob(pattern)
+		for _, file := range files {
+			data, err := ioutil.ReadFile(file)
+			if err != nil {
+				f.Fatal(err)
+			}
+			f.Add(string(data))
+		}
+	}
+}
+
+// tryParse parses source, failing t if the parser crashes rather than
+// reporting a syntax error.
+func tryParse(t *testing.T, source string) (node *Node) {
+	defer func() {
+		if r := recover(); r != nil {
+			if err, ok := r.(runtime.Error); ok {
+				t.Fatalf("parser crashed: %v", err)
+			}
+			node = nil
+		}
+	}()
+
+	return Parse(tokenizer.Tokenize(source))
+}
+
+// source renders the tokens of node back to Jack source.
+func source(node *Node) string {
+	if node.isToken() {
+		if node.Name == "stringConstant" {
+			return `"` + node.Value + `"`
+		}
+		return node.Value
+	}
+
+	words := []string{}
+	for _, child := range node.Children {
+		words = append(words, source(child))
+	}
+
+	return strings.Join(words, " ")
+}
+
+func FuzzParse(f *testing.F) {
+	addSeeds(f)
+
+	f.Fuzz(func(t *testing.T, code string) {
+		node := tryParse(t, code)
+		if node == nil {
+			return
+		}
+
+		xml := node.ToXML()
+
+		reparsed := tryParse(t, source(node))
+		if reparsed == nil {
+			t.Fatalf("failed to parse the rendered source:\n%s", source(node))
+		}
+
+		if reparsed.ToXML() != xml {
+			t.Fatalf("round trip changed the tree:\n%s\nwant:\n%s", reparsed.ToXML(), xml)
+		}
+	})
+}
@@ -1,8 +1,11 @@
 package parser
 
 import (
+	"encoding/json"
 	"fmt"
 	"html"
+	"strconv"
+	"strings"
 
 	"github.com/uiureo/jack/tokenizer"
 )
@@ -11,12 +14,120 @@ type Node struct {
 	Name     string
 	Value    string
 	Children []*Node
+
+	// Line and Column are the source position of a token node, and Width
+	// the length of its source, if known.
+	Line, Column, Width int
 }
 
 func (node *Node) ToXML() string {
 	return generateXMLWithIndent(node, 0)
 }
 
+// ToJSON dumps the tree as JSON. Every node has a `kind` and a `span`, token
+// nodes have a `value` and the others have `children`:
+//
+//	{"kind": "keyword", "value": "class", "span": {"start": {"line": 1, "column": 1}, "end": {"line": 1, "column": 6}}}
+//
+// The end of a span is the position just after its last character.
+func (node *Node) ToJSON() string {
+	data, _ := json.MarshalIndent(node.toJSONNode(), "", "  ")
+
+	return string(data) + "\n"
+}
+
+type jsonNode struct {
+	Kind     string       `json:"kind"`
+	Value    *string      `json:"value,omitempty"`
+	Span     *jsonSpan    `json:"span,omitempty"`
+	Children *[]*jsonNode `json:"children,omitempty"`
+}
+
+type jsonSpan struct {
+	Start jsonPosition `json:"start"`
+	End   jsonPosition `json:"end"`
+}
+
+type jsonPosition struct {
+	Line   int `json:"line"`
+	Column int `json:"column"`
+}
+
+func (node *Node) toJSONNode() *jsonNode {
+	result := &jsonNode{Kind: node.Name}
+
+	if node.isToken() {
+		value := node.Value
+		result.Value = &value
+	} else {
+		children := []*jsonNode{}
+		for _, child := range node.Children {
+			children = append(children, child.toJSONNode())
+		}
+		result.Children = &children
+	}
+
+	if startLine, startColumn, endLine, endColumn := node.Span(); startLine > 0 {
+		result.Span = &jsonSpan{
+			Start: jsonPosition{startLine, startColumn},
+			End:   jsonPosition{endLine, endColumn},
+		}
+	}
+
+	return result
+}
+
+// ToSexp dumps the tree as an S-expression, one node per line:
+//
+//	(class
+//	  (keyword "class")
+//	  (identifier "Main") ...)
+func (node *Node) ToSexp() string {
+	return generateSexpWithIndent(node, 0) + "\n"
+}
+
+func generateSexpWithIndent(node *Node, indent int) string {
+	if node.isToken() {
+		return fmt.Sprintf("(%s %s)", node.Name, strconv.Quote(node.Value))
+	}
+
+	result := "(" + node.Name
+	for _, child := range node.Children {
+		result += "\n" + strings.Repeat(" ", indent+2) + generateSexpWithIndent(child, indent+2)
+	}
+
+	return result + ")"
+}
+
+func (node *Node) isToken() bool {
+	return len(node.Value) > 0 || node.Line > 0
+}
+
+// Span returns the source range covered by node, from the first character
+// of its first token to just after its last token.
+func (node *Node) Span() (startLine, startColumn, endLine, endColumn int) {
+	startLine, startColumn = node.Pos()
+
+	last := node
+	for len(last.Children) > 0 && !last.isToken() {
+		last = last.Children[len(last.Children)-1]
+	}
+
+	if last.Line == 0 {
+		return startLine, startColumn, startLine, startColumn
+	}
+
+	width := last.Width
+	if width == 0 {
+		width = len(last.Value)
+		if last.Name == "stringConstant" {
+			width += len(`""`)
+		}
+	}
+
+	return startLine, startColumn, last.Line, last.Column + width
+}
+
 func (node *Node) AppendToken(token *tokenizer.Token) {
 	node.Children = append(node.Children, tokenToNode(token))
 }
@@ -28,6 +139,21 @@ func (n *Node) AppendChild(node *Node) {
 	n.Children = append(n.Children, node)
 }
 
+// Pos returns the source position of the first token under node.
+func (node *Node) Pos() (line, column int) {
+	if node.Line > 0 {
+		return node.Line, node.Column
+	}
+
+	for _, child := range node.Children {
+		if line, column := child.Pos(); line > 0 {
+			return line, column
+		}
+	}
+
+	return 0, 0
+}
+
 func (node *Node) Find(query *Node) (*Node, int) {
 	for i, childNode := range node.Children {
 		if childNode.Name == query.Name && (len(query.Value) == 0 || childNode.Value == query.Value) {
@@ -61,7 +187,7 @@ func generateXMLWithIndent(node *Node, indent int) string {
 		spaces += " "
 	}
 
-	if len(node.Value) > 0 {
+	if node.isToken() {
 		result += fmt.Sprintf(spaces+"<%v> %v </%v>\n", node.Name, html.EscapeString(node.Value), node.Name)
 	} else {
 		result += fmt.Sprintf(spaces+"<%v>\n", node.Name)
@@ -1,9 +1,13 @@
 package parser
 
 import (
+	"encoding/json"
+	"fmt"
 	"strconv"
 	"strings"
 	"testing"
+
+	"github.com/uiureo/jack/tokenizer"
 )
 
 func TestToXML(t *testing.T) {
@@ -40,3 +44,65 @@ func TestFindAll(t *testing.T) {
 		t.Errorf("`FindAll` should return all `expression`: %v", node.ToXML())
 	}
 }
+
+func TestToJSON(t *testing.T) {
+	root, _ := parseClass(tokenizer.Tokenize(`class Main {
+  function void main() {
+    do Output.printString("hi");
+    return;
+  }
+}`))
+
+	var tree map[string]interface{}
+	if err := json.Unmarshal([]byte(root.ToJSON()), &tree); err != nil {
+		t.Fatal(err)
+	}
+
+	span := tree["span"].(map[string]interface{})
+	if tree["kind"] != "class" || fmt.Sprint(span["start"]) != "map[column:1 line:1]" || fmt.Sprint(span["end"]) != "map[column:2 line:6]" {
+		t.Errorf("unexpected class node: %v %v", tree["kind"], span)
+	}
+
+	keyword := tree["children"].([]interface{})[0].(map[string]interface{})
+	if keyword["kind"] != "keyword" || keyword["value"] != "class" || keyword["children"] != nil {
+		t.Errorf("unexpected keyword node: %v", keyword)
+	}
+
+	statement := root.Children[3].Children[6].Children[1].Children[0]
+	if line, column, endLine, endColumn := statement.Span(); line != 3 || column != 5 || endLine != 3 || endColumn != 33 {
+		t.Errorf("doStatement spans %d:%d-%d:%d, want 3:5-3:33", line, column, endLine, endColumn)
+	}
+}
+
+func TestToSexp(t *testing.T) {
+	node, _ := parseReturnStatement(tokenizer.Tokenize(`return "a";`))
+
+	expected := `(returnStatement
+  (keyword "return")
+  (expression
+    (term
+      (stringConstant "a")))
+  (symbol ";"))
+`
+
+	if node.ToSexp() != expected {
+		t.Errorf("expect:\n%s\ngot:\n%s", expected, node.ToSexp())
+	}
+}
+
+func TestSpanOfExtLiterals(t *testing.T) {
+	node, _ := parseLet(withEOF(tokenizer.TokenizeExt(`let s = "\"";`)))
+	if _, _, _, endColumn := node.Span(); endColumn != 13 {
+		t.Errorf("expect the string to end at column 13, got %d", endColumn)
+	}
+
+	node, _ = parseLet(withEOF(tokenizer.TokenizeExt(`let c = 'A';`)))
+	if _, _, _, endColumn := node.Span(); endColumn != 12 {
+		t.Errorf("expect the character to end at column 12, got %d", endColumn)
+	}
+
+	expected := "<stringConstant>  </stringConstant>\n"
+	if xml := tokenToNode(tokenizer.TokenizeExt(`""`)[0]).ToXML(); xml != expected {
+		t.Errorf("expect `%s`, got `%s`", expected, xml)
+	}
+}
@@ -1,17 +1,53 @@
 package parser
 
 import (
-	"fmt"
-
 	"github.com/uiureo/jack/tokenizer"
 )
 
+// Parse parses a class declaration, or an interface declaration with the
+// extensions of ParseExt. It returns nil if tokens don't start with `class`,
+// and panics with a message on syntax errors.
 func Parse(tokens []*tokenizer.Token) *Node {
+	tokens = withEOF(tokens)
+
 	node, _ := parseClass(tokens)
+	if node == nil && extensions {
+		node, _ = parseInterface(tokens)
+	}
 
 	return node
 }
 
+// extensions makes the parser accept the language extensions written with
+// plain Jack tokens, such as `else if`. It is set during ParseExt.
+var extensions = false
+
+// ParseExt parses a class written with the Jack language extensions, from
+// the tokens of tokenizer.TokenizeExt.
+func ParseExt(tokens []*tokenizer.Token) *Node {
+	extensions = true
+	defer func() { extensions = false }()
+
+	return Parse(tokens)
+}
+
+// withEOF terminates tokens with an eof token, which matches no expectation,
+// so that the parser can look one token ahead without running out of tokens.
+func withEOF(tokens []*tokenizer.Token) []*tokenizer.Token {
+	line, column := 1, 1
+
+	if len(tokens) > 0 {
+		last := tokens[len(tokens)-1]
+		if last.TokenType == "eof" {
+			return tokens
+		}
+
+		line, column = last.Line, last.Column+len(last.Value)
+	}
+
+	return append(tokens[:len(tokens):len(tokens)], &tokenizer.Token{TokenType: "eof", Line: line, Column: column})
+}
+
 func parseClass(tokens []*tokenizer.Token) (*Node, []*tokenizer.Token) {
 	if !(tokens[0].TokenType == "keyword" && tokens[0].Value == "class") {
 		return nil, tokens
@@ -23,13 +59,46 @@ func parseClass(tokens []*tokenizer.Token) (*Node, []*tokenizer.Token) {
 	expect(tokens[1], "identifier", "")
 	node.AppendToken(tokens[1])
 
-	expect(tokens[2], "symbol", "{")
-	node.AppendToken(tokens[2])
+	tokens = tokens[2:]
+	if tokens[0].TokenType == "keyword" && tokens[0].Value == "extends" {
+		node.AppendToken(tokens[0])
 
-	tokens = tokens[3:]
+		expect(tokens[1], "identifier", "")
+		node.AppendToken(tokens[1])
+
+		tokens = tokens[2:]
+	}
+
+	if tokens[0].TokenType == "keyword" && tokens[0].Value == "implements" {
+		node.AppendToken(tokens[0])
+
+		expect(tokens[1], "identifier", "")
+		node.AppendToken(tokens[1])
+
+		tokens = tokens[2:]
+		for tokens[0].TokenType == "symbol" && tokens[0].Value == "," {
+			node.AppendToken(tokens[0])
+
+			expect(tokens[1], "identifier", "")
+			node.AppendToken(tokens[1])
+
+			tokens = tokens[2:]
+		}
+	}
+
+	expect(tokens[0], "symbol", "{")
+	node.AppendToken(tokens[0])
+
+	tokens = tokens[1:]
 
 	for {
 		classVarDec, rest := parseClassVarDec(tokens)
+		if classVarDec == nil {
+			classVarDec, rest = parseConstDec(tokens)
+		}
+		if classVarDec == nil {
+			classVarDec, rest = parseEnumDec(tokens)
+		}
 		if classVarDec == nil {
 			break
 		}
@@ -55,6 +124,59 @@ func parseClass(tokens []*tokenizer.Token) (*Node, []*tokenizer.Token) {
 	return node, tokens[1:]
 }
 
+// parseInterface parses `interface Name { method type name(...); ... }`, an
+// extension of Jack. Each method is a subroutineSig node.
+func parseInterface(tokens []*tokenizer.Token) (*Node, []*tokenizer.Token) {
+	if !(tokens[0].TokenType == "keyword" && tokens[0].Value == "interface") {
+		return nil, tokens
+	}
+
+	node := &Node{Name: "interface", Children: []*Node{}}
+	node.AppendToken(tokens[0])
+
+	expect(tokens[1], "identifier", "")
+	node.AppendToken(tokens[1])
+
+	expect(tokens[2], "symbol", "{")
+	node.AppendToken(tokens[2])
+
+	tokens = tokens[3:]
+	for tokens[0].TokenType == "keyword" && tokens[0].Value == "method" {
+		signature := &Node{Name: "subroutineSig", Children: []*Node{}}
+		signature.AppendToken(tokens[0])
+
+		if !(tokens[1].IsType() || (tokens[1].TokenType == "keyword" && tokens[1].Value == "void")) {
+			unexpected(tokens[1], "type or `void`")
+		}
+		signature.AppendToken(tokens[1])
+
+		expect(tokens[2], "identifier", "")
+		signature.AppendToken(tokens[2])
+
+		expect(tokens[3], "symbol", "(")
+		signature.AppendToken(tokens[3])
+
+		parameterList, rest := parseParameterList(tokens[4:])
+		signature.AppendChild(parameterList)
+
+		expect(rest[0], "symbol", ")")
+		signature.AppendToken(rest[0])
+
+		expect(rest[1], "symbol", ";")
+		signature.AppendToken(rest[1])
+
+		node.AppendChild(signature)
+		tokens = rest[2:]
+	}
+
+	if !(tokens[0].TokenType == "symbol" && tokens[0].Value == "}") {
+		unexpected(tokens[0], "`method` or `}`")
+	}
+	node.AppendToken(tokens[0])
+
+	return node, tokens[1:]
+}
+
 func parseClassVarDec(tokens []*tokenizer.Token) (*Node, []*tokenizer.Token) {
 	if !(tokens[0].TokenType == "keyword" && (tokens[0].Value == "static" || tokens[0].Value == "field")) {
 		return nil, tokens
@@ -64,7 +186,7 @@ func parseClassVarDec(tokens []*tokenizer.Token) (*Node, []*tokenizer.Token) {
 	node.AppendToken(tokens[0])
 
 	if !tokens[1].IsType() {
-		panic(fmt.Sprintf("unexpected token `%s`, expecting type token", tokens[1].TokenType))
+		unexpected(tokens[1], "type")
 	}
 	node.AppendToken(tokens[1])
 
@@ -91,6 +213,82 @@ func parseClassVarDec(tokens []*tokenizer.Token) (*Node, []*tokenizer.Token) {
 	return node, tokens[1:]
 }
 
+// parseConstDec parses `const int NAME = value;`, an extension of Jack. The
+// value is an integer constant, negated or not, `true` or `false`.
+func parseConstDec(tokens []*tokenizer.Token) (*Node, []*tokenizer.Token) {
+	if !(tokens[0].TokenType == "keyword" && tokens[0].Value == "const") {
+		return nil, tokens
+	}
+
+	node := &Node{Name: "constDec", Children: []*Node{}}
+	node.AppendToken(tokens[0])
+
+	if !tokens[1].IsType() {
+		unexpected(tokens[1], "type")
+	}
+	node.AppendToken(tokens[1])
+
+	expect(tokens[2], "identifier", "")
+	node.AppendToken(tokens[2])
+
+	expect(tokens[3], "symbol", "=")
+	node.AppendToken(tokens[3])
+
+	tokens = tokens[4:]
+	if tokens[0].TokenType == "symbol" && tokens[0].Value == "-" {
+		node.AppendToken(tokens[0])
+		expect(tokens[1], "integerConstant", "")
+		tokens = tokens[1:]
+	}
+
+	switch {
+	case tokens[0].TokenType == "integerConstant":
+	case tokens[0].TokenType == "keyword" && (tokens[0].Value == "true" || tokens[0].Value == "false"):
+	default:
+		unexpected(tokens[0], "constant")
+	}
+	node.AppendToken(tokens[0])
+
+	expect(tokens[1], "symbol", ";")
+	node.AppendToken(tokens[1])
+
+	return node, tokens[2:]
+}
+
+// parseEnumDec parses `enum Name { A, B }`, an extension of Jack.
+func parseEnumDec(tokens []*tokenizer.Token) (*Node, []*tokenizer.Token) {
+	if !(tokens[0].TokenType == "keyword" && tokens[0].Value == "enum") {
+		return nil, tokens
+	}
+
+	node := &Node{Name: "enumDec", Children: []*Node{}}
+	node.AppendToken(tokens[0])
+
+	expect(tokens[1], "identifier", "")
+	node.AppendToken(tokens[1])
+
+	expect(tokens[2], "symbol", "{")
+	node.AppendToken(tokens[2])
+
+	expect(tokens[3], "identifier", "")
+	node.AppendToken(tokens[3])
+
+	tokens = tokens[4:]
+	for tokens[0].TokenType == "symbol" && tokens[0].Value == "," {
+		node.AppendToken(tokens[0])
+
+		expect(tokens[1], "identifier", "")
+		node.AppendToken(tokens[1])
+
+		tokens = tokens[2:]
+	}
+
+	expect(tokens[0], "symbol", "}")
+	node.AppendToken(tokens[0])
+
+	return node, tokens[1:]
+}
+
 func parseSubroutineDec(tokens []*tokenizer.Token) (*Node, []*tokenizer.Token) {
 	if !(tokens[0].TokenType == "keyword" && (tokens[0].Value == "constructor" || tokens[0].Value == "function" || tokens[0].Value == "method")) {
 		return nil, tokens
@@ -98,7 +296,11 @@ func parseSubroutineDec(tokens []*tokenizer.Token) (*Node, []*tokenizer.Token) {
 
 	node := &Node{Name: "subroutineDec", Children: []*Node{}}
 	node.AppendToken(tokens[0])
-	node.AppendToken(tokens[1]) // "void" || identifier
+
+	if !(tokens[1].IsType() || (tokens[1].TokenType == "keyword" && tokens[1].Value == "void")) {
+		unexpected(tokens[1], "type or `void`")
+	}
+	node.AppendToken(tokens[1])
 
 	expect(tokens[2], "identifier", "")
 	node.AppendToken(tokens[2])
@@ -141,7 +343,7 @@ func parseSubroutineBody(tokens []*tokenizer.Token) (*Node, []*tokenizer.Token)
 		tokens = rest
 	}
 
-	statements, tokens := ParseStatements(tokens)
+	statements, tokens := parseStatements(tokens)
 	node.Children = append(node.Children, statements)
 
 	expect(tokens[0], "symbol", "}")
@@ -165,6 +367,9 @@ func parseParameterList(tokens []*tokenizer.Token) (*Node, []*tokenizer.Token) {
 			if tokens[0].TokenType == "symbol" && tokens[0].Value == "," {
 				node.AppendToken(tokens[0])
 
+				if !tokens[1].IsType() {
+					unexpected(tokens[1], "type")
+				}
 				node.AppendToken(tokens[1])
 
 				expect(tokens[2], "identifier", "")
@@ -189,7 +394,7 @@ func parseVarDec(tokens []*tokenizer.Token) (*Node, []*tokenizer.Token) {
 	node.AppendToken(tokens[0])
 
 	if !tokens[1].IsType() {
-		panic("unexpected token `" + tokens[1].Value + "`, expecting type")
+		unexpected(tokens[1], "type")
 	}
 	node.AppendToken(tokens[1])
 
@@ -215,7 +420,13 @@ func parseVarDec(tokens []*tokenizer.Token) (*Node, []*tokenizer.Token) {
 	return node, tokens[1:]
 }
 
+// ParseStatements parses a sequence of statements, returning the tokens that
+// follow them, terminated by an eof token.
 func ParseStatements(tokens []*tokenizer.Token) (*Node, []*tokenizer.Token) {
+	return parseStatements(withEOF(tokens))
+}
+
+func parseStatements(tokens []*tokenizer.Token) (*Node, []*tokenizer.Token) {
 	node := &Node{Name: "statements", Children: []*Node{}}
 
 	for {
@@ -256,21 +467,55 @@ func parseStatement(tokens []*tokenizer.Token) (*Node, []*tokenizer.Token) {
 		return node, rest
 	}
 
+	if node, rest := parseForStatement(tokens); node != nil {
+		return node, rest
+	}
+
+	if node, rest := parseSwitchStatement(tokens); node != nil {
+		return node, rest
+	}
+
+	if node, rest := parseJumpStatement(tokens, "break"); node != nil {
+		return node, rest
+	}
+
+	if node, rest := parseJumpStatement(tokens, "continue"); node != nil {
+		return node, rest
+	}
+
 	return nil, tokens
 }
 
 func expect(token *tokenizer.Token, tokenType, value string) {
 	if len(value) == 0 {
 		if token.TokenType != tokenType {
+			if token.TokenType == "eof" {
+				unexpected(token, "`"+tokenType+"`")
+			}
 			panic("unexpected token `" + token.TokenType + "." + token.Value + "`, expecting `" + tokenType + "`")
 		}
 	} else {
 		if !(token.TokenType == tokenType && token.Value == value) {
-			panic("unexpected token `" + token.Value + "`, expecting `" + value + "`")
+			unexpected(token, "`"+value+"`")
 		}
 	}
 }
 
+func unexpected(token *tokenizer.Token, expecting string) {
+	if token.TokenType == "eof" {
+		panic("unexpected end of input, expecting " + expecting)
+	}
+
+	panic("unexpected token `" + token.Value + "`, expecting " + expecting)
+}
+
+// expectExpression panics unless an expression was parsed from tokens.
+func expectExpression(expression *Node, tokens []*tokenizer.Token) {
+	if expression == nil {
+		unexpected(tokens[0], "expression")
+	}
+}
+
 func parseIfStatement(tokens []*tokenizer.Token) (*Node, []*tokenizer.Token) {
 	if !(tokens[0].TokenType == "keyword" && tokens[0].Value == "if") {
 		return nil, tokens
@@ -283,6 +528,7 @@ func parseIfStatement(tokens []*tokenizer.Token) (*Node, []*tokenizer.Token) {
 	node.AppendToken(tokens[1]) // (
 
 	expression, rest := parseExpression(tokens[2:])
+	expectExpression(expression, rest)
 	node.Children = append(node.Children, expression)
 
 	expect(rest[0], "symbol", ")")
@@ -290,7 +536,7 @@ func parseIfStatement(tokens []*tokenizer.Token) (*Node, []*tokenizer.Token) {
 	expect(rest[1], "symbol", "{")
 	node.AppendToken(rest[1]) // {
 
-	statements, rest := ParseStatements(rest[2:])
+	statements, rest := parseStatements(rest[2:])
 	node.Children = append(node.Children, statements)
 
 	expect(rest[0], "symbol", "}")
@@ -301,10 +547,18 @@ func parseIfStatement(tokens []*tokenizer.Token) (*Node, []*tokenizer.Token) {
 	if len(rest) > 0 && rest[0].TokenType == "keyword" && rest[0].Value == "else" {
 		node.AppendToken(rest[0])
 
+		// `else if` is an else branch made of a single if statement
+		if extensions {
+			if elseIf, elseRest := parseIfStatement(rest[1:]); elseIf != nil {
+				node.AppendChild(&Node{Name: "statements", Children: []*Node{elseIf}})
+				return node, elseRest
+			}
+		}
+
 		expect(rest[1], "symbol", "{")
 		node.AppendToken(rest[1])
 
-		statements, rest = ParseStatements(rest[2:])
+		statements, rest = parseStatements(rest[2:])
 		node.Children = append(node.Children, statements)
 
 		expect(rest[0], "symbol", "}")
@@ -317,37 +571,111 @@ func parseIfStatement(tokens []*tokenizer.Token) (*Node, []*tokenizer.Token) {
 }
 
 func parseLetStatement(tokens []*tokenizer.Token) (*Node, []*tokenizer.Token) {
-	if !(tokens[0].TokenType == "keyword" && tokens[0].Value == "let") {
+	node, rest := parseLet(tokens)
+	if node == nil {
 		return nil, tokens
 	}
+
+	expect(rest[0], "symbol", ";")
+	node.AppendToken(rest[0])
+
+	return node, rest[1:]
+}
+
+// parseLet parses a let statement without its closing `;`, as in the update
+// of a for loop. With the extensions, `let` may be omitted, and `x += e`,
+// `x -= e`, `x++` and `x--` become `let x = x + (e);` and so on.
+func parseLet(tokens []*tokenizer.Token) (*Node, []*tokenizer.Token) {
 	node := &Node{Name: "letStatement", Children: []*Node{}}
+
+	switch {
+	case tokens[0].TokenType == "keyword" && tokens[0].Value == "let":
+		node.AppendToken(tokens[0])
+		tokens = tokens[1:]
+	case extensions && tokens[0].TokenType == "identifier":
+		node.AppendChild(syntheticNode(tokens[0], "keyword", "let"))
+	default:
+		return nil, tokens
+	}
+
+	expect(tokens[0], "identifier", "")
 	node.AppendToken(tokens[0])
 
-	expect(tokens[1], "identifier", "")
-	node.AppendToken(tokens[1])
+	target := &Node{Name: "term", Children: []*Node{tokenToNode(tokens[0])}}
 
-	tokens = tokens[2:]
+	tokens = tokens[1:]
 	if tokens[0].TokenType == "symbol" && tokens[0].Value == "[" {
 		node.AppendToken(tokens[0])
 
 		expression, rest := parseExpression(tokens[1:])
+		expectExpression(expression, rest)
 		node.AppendChild(expression)
 
 		expect(rest[0], "symbol", "]")
 		node.AppendToken(rest[0])
 
+		// the target shares the index, which the compiler evaluates once
+		target.Children = append(target.Children, node.Children[2:]...)
+
 		tokens = rest[1:]
 	}
 
+	if extensions && tokens[0].IsCompoundAssignment() {
+		return parseCompoundAssignment(node, target, tokens)
+	}
+
 	expect(tokens[0], "symbol", "=")
 	node.AppendToken(tokens[0])
 	expression, rest := parseExpression(tokens[1:])
+	expectExpression(expression, rest)
 	node.Children = append(node.Children, expression)
 
-	expect(rest[0], "symbol", ";")
-	node.AppendToken(rest[0])
+	return node, rest
+}
 
-	return node, rest[1:]
+// parseCompoundAssignment completes a let statement for `+= e`, `-= e`, `++`
+// or `--`, assigning target op operand to target.
+func parseCompoundAssignment(node, target *Node, tokens []*tokenizer.Token) (*Node, []*tokenizer.Token) {
+	operator := tokens[0]
+	node.AppendChild(syntheticNode(operator, "symbol", "="))
+
+	var operand *Node
+	rest := tokens[1:]
+	if operator.Value == "++" || operator.Value == "--" {
+		// 1 spans `++` or `--`
+		one := syntheticNode(operator, "integerConstant", "1")
+		one.Width = len(operator.Value)
+
+		operand = &Node{Name: "term", Children: []*Node{one}}
+	} else {
+		var expression *Node
+		expression, rest = parseExpression(rest)
+		expectExpression(expression, rest)
+
+		// `)` ends where e does
+		_, _, endLine, endColumn := expression.Span()
+		closing := &Node{Name: "symbol", Value: ")", Line: endLine, Column: endColumn - 1}
+
+		operand = &Node{Name: "term", Children: []*Node{
+			syntheticNode(operator, "symbol", "("),
+			expression,
+			closing,
+		}}
+	}
+
+	node.AppendChild(&Node{Name: "expression", Children: []*Node{
+		target,
+		syntheticNode(operator, "symbol", operator.Value[:1]),
+		operand,
+	}})
+
+	return node, rest
+}
+
+// syntheticNode returns a token node that isn't in the source, at the
+// position of the token it stands for.
+func syntheticNode(token *tokenizer.Token, name, value string) *Node {
+	return &Node{Name: name, Value: value, Line: token.Line, Column: token.Column}
 }
 
 func parseWhileStatement(tokens []*tokenizer.Token) (*Node, []*tokenizer.Token) {
@@ -362,15 +690,63 @@ func parseWhileStatement(tokens []*tokenizer.Token) (*Node, []*tokenizer.Token)
 	node.AppendToken(tokens[1])
 
 	expression, rest := parseExpression(tokens[2:])
+	expectExpression(expression, rest)
+	node.AppendChild(expression)
+
+	expect(rest[0], "symbol", ")")
+	node.AppendToken(rest[0])
+
+	expect(rest[1], "symbol", "{")
+	node.AppendToken(rest[1])
+
+	statements, rest := parseStatements(rest[2:])
+	node.AppendChild(statements)
+
+	expect(rest[0], "symbol", "}")
+	node.AppendToken(rest[0])
+
+	return node, rest[1:]
+}
+
+// parseForStatement parses `for (let ...; expression; let ...) { ... }`, an
+// extension of Jack.
+func parseForStatement(tokens []*tokenizer.Token) (*Node, []*tokenizer.Token) {
+	if !(tokens[0].TokenType == "keyword" && tokens[0].Value == "for") {
+		return nil, tokens
+	}
+
+	node := &Node{Name: "forStatement", Children: []*Node{}}
+	node.AppendToken(tokens[0]) // for
+
+	expect(tokens[1], "symbol", "(")
+	node.AppendToken(tokens[1])
+
+	initialization, rest := parseLetStatement(tokens[2:])
+	if initialization == nil {
+		unexpected(rest[0], "`let`")
+	}
+	node.AppendChild(initialization)
+
+	expression, rest := parseExpression(rest)
+	expectExpression(expression, rest)
 	node.AppendChild(expression)
 
+	expect(rest[0], "symbol", ";")
+	node.AppendToken(rest[0])
+
+	update, rest := parseLet(rest[1:])
+	if update == nil {
+		unexpected(rest[0], "`let`")
+	}
+	node.AppendChild(update)
+
 	expect(rest[0], "symbol", ")")
 	node.AppendToken(rest[0])
 
 	expect(rest[1], "symbol", "{")
 	node.AppendToken(rest[1])
 
-	statements, rest := ParseStatements(rest[2:])
+	statements, rest := parseStatements(rest[2:])
 	node.AppendChild(statements)
 
 	expect(rest[0], "symbol", "}")
@@ -379,6 +755,92 @@ func parseWhileStatement(tokens []*tokenizer.Token) (*Node, []*tokenizer.Token)
 	return node, rest[1:]
 }
 
+// parseSwitchStatement parses `switch (expression) { case 1: ... default:
+// ... }`, an extension of Jack. Each case is a switchCase node holding its
+// label, an integer constant such as `-1` or a constant such as `Main.ONE`,
+// and its statements.
+func parseSwitchStatement(tokens []*tokenizer.Token) (*Node, []*tokenizer.Token) {
+	if !(tokens[0].TokenType == "keyword" && tokens[0].Value == "switch") {
+		return nil, tokens
+	}
+
+	node := &Node{Name: "switchStatement", Children: []*Node{}}
+	node.AppendToken(tokens[0]) // switch
+
+	expect(tokens[1], "symbol", "(")
+	node.AppendToken(tokens[1])
+
+	expression, rest := parseExpression(tokens[2:])
+	expectExpression(expression, rest)
+	node.AppendChild(expression)
+
+	expect(rest[0], "symbol", ")")
+	node.AppendToken(rest[0])
+
+	expect(rest[1], "symbol", "{")
+	node.AppendToken(rest[1])
+
+	rest = rest[2:]
+	for rest[0].TokenType == "keyword" && (rest[0].Value == "case" || rest[0].Value == "default") {
+		switchCase := &Node{Name: "switchCase", Children: []*Node{}}
+		switchCase.AppendToken(rest[0])
+
+		if rest[0].Value == "case" {
+			rest = rest[1:]
+			if rest[0].TokenType == "symbol" && rest[0].Value == "-" {
+				switchCase.AppendToken(rest[0])
+				rest = rest[1:]
+				expect(rest[0], "integerConstant", "")
+			}
+
+			switch {
+			case rest[0].TokenType == "integerConstant":
+				switchCase.AppendToken(rest[0])
+			case rest[0].TokenType == "identifier":
+				expect(rest[1], "symbol", ".")
+				expect(rest[2], "identifier", "")
+				switchCase.AppendToken(rest[0])
+				switchCase.AppendToken(rest[1])
+				switchCase.AppendToken(rest[2])
+				rest = rest[2:]
+			default:
+				unexpected(rest[0], "integer constant or `Class.CONST`")
+			}
+		}
+
+		expect(rest[1], "symbol", ":")
+		switchCase.AppendToken(rest[1])
+
+		var statements *Node
+		statements, rest = parseStatements(rest[2:])
+		switchCase.AppendChild(statements)
+
+		node.AppendChild(switchCase)
+	}
+
+	if rest[0].TokenType != "symbol" || rest[0].Value != "}" {
+		unexpected(rest[0], "`case`, `default` or `}`")
+	}
+	node.AppendToken(rest[0])
+
+	return node, rest[1:]
+}
+
+// parseJumpStatement parses `break;` or `continue;`, extensions of Jack.
+func parseJumpStatement(tokens []*tokenizer.Token, keyword string) (*Node, []*tokenizer.Token) {
+	if !(tokens[0].TokenType == "keyword" && tokens[0].Value == keyword) {
+		return nil, tokens
+	}
+
+	node := &Node{Name: keyword + "Statement", Children: []*Node{}}
+	node.AppendToken(tokens[0])
+
+	expect(tokens[1], "symbol", ";")
+	node.AppendToken(tokens[1])
+
+	return node, tokens[2:]
+}
+
 func parseDoStatement(tokens []*tokenizer.Token) (*Node, []*tokenizer.Token) {
 	if !(tokens[0].TokenType == "keyword" && tokens[0].Value == "do") {
 		return nil, tokens
@@ -388,6 +850,9 @@ func parseDoStatement(tokens []*tokenizer.Token) (*Node, []*tokenizer.Token) {
 	node.AppendToken(tokens[0]) // do
 
 	subroutineCallNodes, rest := parseSubroutineCall(tokens[1:])
+	if len(subroutineCallNodes) == 0 {
+		unexpected(tokens[1], "subroutine call")
+	}
 	for _, n := range subroutineCallNodes {
 		node.AppendChild(n)
 	}
@@ -432,14 +897,43 @@ func parseExpression(tokens []*tokenizer.Token) (*Node, []*tokenizer.Token) {
 
 		node.AppendToken(restTokens[0])
 		termNode, rest := parseTerm(restTokens[1:])
+		if termNode == nil {
+			unexpected(rest[0], "term")
+		}
 		node.Children = append(node.Children, termNode)
 
 		restTokens = rest
 	}
 
+	if extensions && restTokens[0].TokenType == "symbol" && restTokens[0].Value == "?" {
+		return parseConditional(node, restTokens)
+	}
+
 	return node, restTokens
 }
 
+// parseConditional parses the rest of `condition ? a : b`, an extension of
+// Jack, which has the lowest precedence: the condition is the whole
+// expression before `?`, and a and b may be conditionals too. The result is
+// an expression holding the three expressions.
+func parseConditional(condition *Node, tokens []*tokenizer.Token) (*Node, []*tokenizer.Token) {
+	node := &Node{Name: "expression", Children: []*Node{condition}}
+	node.AppendToken(tokens[0])
+
+	consequent, rest := parseExpression(tokens[1:])
+	expectExpression(consequent, rest)
+	node.AppendChild(consequent)
+
+	expect(rest[0], "symbol", ":")
+	node.AppendToken(rest[0])
+
+	alternative, rest := parseExpression(rest[1:])
+	expectExpression(alternative, rest)
+	node.AppendChild(alternative)
+
+	return node, rest
+}
+
 func parseExpressionList(tokens []*tokenizer.Token) (*Node, []*tokenizer.Token) {
 	node := &Node{Name: "expressionList", Children: []*Node{}}
 
@@ -448,12 +942,14 @@ func parseExpressionList(tokens []*tokenizer.Token) (*Node, []*tokenizer.Token)
 	}
 
 	expression, rest := parseExpression(tokens)
+	expectExpression(expression, rest)
 	node.Children = append(node.Children, expression)
 
 	for {
 		if rest[0].TokenType == "symbol" && rest[0].Value == "," {
 			node.AppendToken(rest[0])
 			expression, tokens := parseExpression(rest[1:])
+			expectExpression(expression, tokens)
 			node.Children = append(node.Children, expression)
 
 			rest = tokens
@@ -472,12 +968,29 @@ func parseTerm(tokens []*tokenizer.Token) (*Node, []*tokenizer.Token) {
 		return node, tokens[1:]
 
 	case "keyword":
+		if !tokens[0].IsKeywordConstant() {
+			return nil, tokens
+		}
+
 		node := &Node{Name: "term", Children: []*Node{}}
 		node.AppendToken(tokens[0])
 
 		return node, tokens[1:]
 	}
 
+	// Class.CONST
+	if extensions && tokens[0].TokenType == "identifier" &&
+		tokens[1].TokenType == "symbol" && tokens[1].Value == "." &&
+		tokens[2].TokenType == "identifier" &&
+		!(tokens[3].TokenType == "symbol" && tokens[3].Value == "(") {
+		node := &Node{Name: "term", Children: []*Node{}}
+		node.AppendToken(tokens[0])
+		node.AppendToken(tokens[1])
+		node.AppendToken(tokens[2])
+
+		return node, tokens[3:]
+	}
+
 	subroutineCallNodes, tokens := parseSubroutineCall(tokens)
 
 	if len(subroutineCallNodes) > 0 {
@@ -493,6 +1006,7 @@ func parseTerm(tokens []*tokenizer.Token) (*Node, []*tokenizer.Token) {
 			node.AppendToken(tokens[0])
 
 			expression, rest := parseExpression(tokens[1:])
+			expectExpression(expression, rest)
 			node.AppendChild(expression)
 
 			expect(rest[0], "symbol", "]")
@@ -510,6 +1024,7 @@ func parseTerm(tokens []*tokenizer.Token) (*Node, []*tokenizer.Token) {
 		node.AppendToken(tokens[0])
 
 		expression, tokens := parseExpression(tokens[1:])
+		expectExpression(expression, tokens)
 		node.AppendChild(expression)
 
 		expect(tokens[0], "symbol", ")")
@@ -526,6 +1041,9 @@ func parseTerm(tokens []*tokenizer.Token) (*Node, []*tokenizer.Token) {
 		node.AppendToken(tokens[0])
 
 		term, tokens := parseTerm(tokens[1:])
+		if term == nil {
+			unexpected(tokens[0], "term")
+		}
 		node.AppendChild(term)
 
 		return node, tokens
@@ -574,5 +1092,5 @@ func parseSubroutineCall(tokens []*tokenizer.Token) ([]*Node, []*tokenizer.Token
 }
 
 func tokenToNode(token *tokenizer.Token) *Node {
-	return &Node{Name: token.TokenType, Value: token.Value}
+	return &Node{Name: token.TokenType, Value: token.Value, Line: token.Line, Column: token.Column, Width: token.Width}
 }
@@ -1,6 +1,7 @@
 package parser
 
 import (
+	"strings"
 	"testing"
 
 	"github.com/uiureo/jack/tokenizer"
@@ -240,3 +241,213 @@ func TestParseVarDec(t *testing.T) {
 		t.Error("parse fails")
 	}
 }
+
+func TestParseForStatement(t *testing.T) {
+	root, _ := ParseStatements(tokenizer.TokenizeExt(`
+    for (let i = 0; i < 10; let i = i + 1) {
+      break;
+    }
+  `))
+
+	statement := root.Children[0]
+	if statement.Name != "forStatement" {
+		t.Fatalf("expect node to have forStatement, but got:\n%v", root.ToXML())
+	}
+
+	lets := statement.FindAll(&Node{Name: "letStatement"})
+	if len(lets) != 2 || len(lets[1].Children) != 4 {
+		t.Errorf("expect an initialization and an update without `;`, but got:\n%v", root.ToXML())
+	}
+
+	body, _ := statement.Find(&Node{Name: "statements"})
+	if body.Children[0].Name != "breakStatement" {
+		t.Errorf("expect body to have breakStatement, but got:\n%v", root.ToXML())
+	}
+}
+
+func TestParseCompoundAssignment(t *testing.T) {
+	tests := map[string]string{
+		"x = 1;":           "let x = 1;",
+		"x += 1;":          "let x = x + (1);",
+		"a[i + 1] -= 2*3;": "let a[i + 1] = a[i + 1] - (2*3);",
+		"x++;":             "let x = x + 1;",
+		"a[i]--;":          "let a[i] = a[i] - 1;",
+	}
+
+	for source, expected := range tests {
+		extensions = true
+		root, _ := ParseStatements(tokenizer.TokenizeExt(source))
+		extensions = false
+		expectedRoot, _ := ParseStatements(tokenizer.Tokenize(expected))
+
+		if root.ToXML() != expectedRoot.ToXML() {
+			t.Errorf("%s: expect the tree of `%s`, but got:\n%v", source, expected, root.ToXML())
+		}
+	}
+
+	root := ParseExt(tokenizer.TokenizeExt(`class Main { function void main() { let x += 10; return; } }`))
+	statement := root.Children[3].Children[6].Children[1].Children[0]
+	if _, _, line, column := statement.Span(); line != 1 || column != 49 {
+		t.Errorf("expect the statement to end at 1:49, got %d:%d", line, column)
+	}
+}
+
+func TestParseConditional(t *testing.T) {
+	extensions = true
+	defer func() { extensions = false }()
+
+	root, _ := ParseStatements(tokenizer.TokenizeExt(`let x = a + 1 > b ? c : d ? e : f + 2;`))
+	expression := root.Children[0].Children[3]
+
+	if len(expression.Children) != 5 || expression.Children[1].Value != "?" || expression.Children[3].Value != ":" {
+		t.Fatalf("expect a conditional expression, but got:\n%v", expression.ToXML())
+	}
+	if condition := expression.Children[0]; len(condition.Children) != 5 {
+		t.Errorf("expect `a + 1 > b` to be the condition, but got:\n%v", condition.ToXML())
+	}
+	if alternative := expression.Children[4]; len(alternative.Children) != 5 || len(alternative.Children[4].Children) != 3 {
+		t.Errorf("expect `d ? e : f + 2` to be the alternative, but got:\n%v", alternative.ToXML())
+	}
+
+	defer func() {
+		if r := recover(); r != "unexpected token `;`, expecting `:`" {
+			t.Errorf("expect a missing `:` to be an error, got %v", r)
+		}
+	}()
+	ParseStatements(tokenizer.TokenizeExt(`let x = a ? b;`))
+}
+
+func TestParseElseIf(t *testing.T) {
+	source := `class Main { function void main() { if (x) { } else if (y) { } else { } return; } }`
+
+	root := ParseExt(tokenizer.TokenizeExt(source))
+	statements := root.Children[3].Children[6].Children[1]
+	ifStatement := statements.Children[0]
+
+	elseBranches := ifStatement.FindAll(&Node{Name: "statements"})
+	if len(elseBranches) != 2 || elseBranches[1].Children[0].Name != "ifStatement" {
+		t.Errorf("expect the else branch to hold an ifStatement, but got:\n%v", ifStatement.ToXML())
+	}
+
+	defer func() {
+		if r := recover(); r != "unexpected token `if`, expecting `{`" {
+			t.Errorf("expect `else if` to be an error in plain Jack, got %v", r)
+		}
+	}()
+	Parse(tokenizer.Tokenize(source))
+}
+
+func TestParseSwitchStatement(t *testing.T) {
+	root, _ := ParseStatements(tokenizer.TokenizeExt(`
+    switch (key) {
+      case 1:
+        let x = 1;
+        let y = 2;
+      case 2:
+      default:
+        return;
+    }
+  `))
+
+	statement := root.Children[0]
+	if statement.Name != "switchStatement" {
+		t.Fatalf("expect node to have switchStatement, but got:\n%v", root.ToXML())
+	}
+
+	switchCases := statement.FindAll(&Node{Name: "switchCase"})
+	if len(switchCases) != 3 {
+		t.Fatalf("expect 3 cases, but got:\n%v", root.ToXML())
+	}
+
+	for i, count := range []int{2, 0, 1} {
+		body, _ := switchCases[i].Find(&Node{Name: "statements"})
+		if len(body.Children) != count {
+			t.Errorf("expect case %d to have %d statements, but got:\n%v", i, count, switchCases[i].ToXML())
+		}
+	}
+}
+
+func TestParseCaseLabels(t *testing.T) {
+	root, _ := ParseStatements(tokenizer.TokenizeExt(`
+    switch (key) {
+      case -1:
+      case Main.ONE:
+        return;
+    }
+  `))
+
+	switchCases := root.Children[0].FindAll(&Node{Name: "switchCase"})
+	if len(switchCases) != 2 {
+		t.Fatalf("expect 2 cases, but got:\n%v", root.ToXML())
+	}
+
+	for i, expected := range [][]string{{"case", "-", "1", ":"}, {"case", "Main", ".", "ONE", ":"}} {
+		values := []string{}
+		for _, child := range switchCases[i].Children[:len(expected)] {
+			values = append(values, child.Value)
+		}
+		if strings.Join(values, " ") != strings.Join(expected, " ") {
+			t.Errorf("expect case %d to be `%s`, but got:\n%v", i, strings.Join(expected, " "), switchCases[i].ToXML())
+		}
+	}
+
+	for _, source := range []string{"case x:", "case -Main.ONE:", "case Main:"} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("expect `%s` to panic", source)
+				}
+			}()
+			ParseStatements(tokenizer.TokenizeExt("switch (key) { " + source + " }"))
+		}()
+	}
+}
+
+func TestParseConstAndEnum(t *testing.T) {
+	root := ParseExt(tokenizer.TokenizeExt(`
+    class Main {
+      const int MIN = -1;
+      enum Direction { UP, DOWN }
+      field int x;
+
+      function int main() {
+        return Main.MIN;
+      }
+    }
+  `))
+
+	names := []string{}
+	for _, child := range root.Children[3:6] {
+		names = append(names, child.Name)
+	}
+	if strings.Join(names, " ") != "constDec enumDec classVarDec" {
+		t.Errorf("expect constDec, enumDec and classVarDec, but got:\n%v", root.ToXML())
+	}
+
+	term := root.Children[6].Children[6].Children[1].Children[0].Children[1].Children[0]
+	if len(term.Children) != 3 || term.Children[2].Value != "MIN" {
+		t.Errorf("expect the term `Main.MIN`, but got:\n%v", term.ToXML())
+	}
+}
+
+func TestParseInterface(t *testing.T) {
+	root := ParseExt(tokenizer.TokenizeExt(`
+    interface Drawable {
+      method void draw(int x, int y);
+    }
+  `))
+
+	if root.Name != "interface" || root.Children[1].Value != "Drawable" {
+		t.Fatalf("expect the interface `Drawable`, but got:\n%v", root.ToXML())
+	}
+
+	signature := root.Children[3]
+	if signature.Name != "subroutineSig" || signature.Children[2].Value != "draw" || len(signature.Children[4].Children) != 5 {
+		t.Errorf("expect the signature of `draw`, but got:\n%v", signature.ToXML())
+	}
+
+	root = ParseExt(tokenizer.TokenizeExt(`class Ball extends Sprite implements Drawable, Movable { }`))
+	if len(root.Children) != 10 || root.Children[7].Value != "Movable" {
+		t.Errorf("expect the interfaces of `Ball`, but got:\n%v", root.ToXML())
+	}
+}
@@ -0,0 +1,182 @@
+package profile
+
+import (
+	"compress/gzip"
+	"io"
+	"sort"
+)
+
+// WritePprof writes the profile in the gzipped protocol buffer format of
+// pprof, with Jack subroutines as functions and Jack lines as locations, so
+// that `go tool pprof` can render it, e.g. as a flame graph.
+func (profile *Profile) WritePprof(w io.Writer) error {
+	strs := []string{""}
+	stringIndex := map[string]int{"": 0}
+	str := func(s string) uint64 {
+		if _, ok := stringIndex[s]; !ok {
+			stringIndex[s] = len(strs)
+			strs = append(strs, s)
+		}
+		return uint64(stringIndex[s])
+	}
+
+	// declaration of each subroutine
+	type declaration struct {
+		file string
+		line int
+	}
+	declarations := map[string]declaration{}
+	for _, class := range profile.project.Classes {
+		for _, subroutine := range class.SourceMap.Subroutines {
+			declarations[subroutine.Name] = declaration{class.JackFile, subroutine.Line}
+		}
+	}
+
+	out := &encoder{}
+
+	valueType := func(field int) {
+		out.message(field, func(e *encoder) {
+			e.uint(1, str("instructions"))
+			e.uint(2, str("count"))
+		})
+	}
+	valueType(1) // sample_type
+
+	functionIDs := map[string]uint64{}
+	functions := &encoder{}
+	functionID := func(name string) uint64 {
+		if id, ok := functionIDs[name]; ok {
+			return id
+		}
+
+		id := uint64(len(functionIDs) + 1)
+		functionIDs[name] = id
+
+		declaration := declarations[name]
+		functions.message(5, func(e *encoder) {
+			e.uint(1, id)
+			e.uint(2, str(name))
+			e.uint(3, str(name))
+			e.uint(4, str(declaration.file))
+			e.uint(5, uint64(declaration.line))
+		})
+
+		return id
+	}
+
+	type location struct {
+		function string
+		line     int
+	}
+	locationIDs := map[location]uint64{}
+	locations := &encoder{}
+	locationID := func(frame frame) uint64 {
+		key := location{function: frame.function}
+		if frame.pc >= 0 {
+			key.line = profile.project.Location(profile.program, frame.pc).Line
+		}
+
+		if id, ok := locationIDs[key]; ok {
+			return id
+		}
+
+		id := uint64(len(locationIDs) + 1)
+		locationIDs[key] = id
+
+		function := functionID(key.function)
+		locations.message(4, func(e *encoder) {
+			e.uint(1, id)
+			e.message(4, func(e *encoder) {
+				e.uint(1, function)
+				e.uint(2, uint64(key.line))
+			})
+		})
+
+		return id
+	}
+
+	keys := []string{}
+	for key := range profile.samples {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		sample := profile.samples[key]
+
+		ids := []uint64{}
+		for _, frame := range sample.stack {
+			ids = append(ids, locationID(frame))
+		}
+
+		out.message(2, func(e *encoder) {
+			e.packed(1, ids)
+			e.packed(2, []uint64{uint64(sample.count)})
+		})
+	}
+
+	out.data = append(out.data, locations.data...)
+	out.data = append(out.data, functions.data...)
+
+	for _, s := range strs {
+		out.bytes(6, []byte(s))
+	}
+
+	valueType(11) // period_type
+	out.uint(12, 1)
+
+	gz := gzip.NewWriter(w)
+	if _, err := gz.Write(out.data); err != nil {
+		return err
+	}
+
+	return gz.Close()
+}
+
+// encoder writes protocol buffer fields.
+type encoder struct {
+	data []byte
+}
+
+func (e *encoder) varint(x uint64) {
+	for x >= 0x80 {
+		e.data = append(e.data, byte(x)|0x80)
+		x >>= 7
+	}
+	e.data = append(e.data, byte(x))
+}
+
+func (e *encoder) key(field, wireType int) {
+	e.varint(uint64(field<<3 | wireType))
+}
+
+func (e *encoder) uint(field int, x uint64) {
+	if x == 0 {
+		return
+	}
+
+	e.key(field, 0)
+	e.varint(x)
+}
+
+func (e *encoder) bytes(field int, b []byte) {
+	e.key(field, 2)
+	e.varint(uint64(len(b)))
+	e.data = append(e.data, b...)
+}
+
+func (e *encoder) packed(field int, xs []uint64) {
+	inner := &encoder{}
+	for _, x := range xs {
+		inner.varint(x)
+	}
+
+	e.bytes(field, inner.data)
+}
+
+func (e *encoder) message(field int, write func(e *encoder)) {
+	inner := &encoder{}
+	write(inner)
+
+	e.bytes(field, inner.data)
+}
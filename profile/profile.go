@@ -0,0 +1,200 @@
+// Package profile counts where a Jack program spends its VM instructions on
+// the VM emulator, by subroutine and by Jack line.
+package profile
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/uiureo/jack/project"
+	"github.com/uiureo/jack/vm"
+)
+
+// Function is the profile of a subroutine, or of an OS function implemented
+// by the emulator, which counts one instruction per call.
+type Function struct {
+	Name  string
+	Calls int
+
+	// Inclusive counts the instructions run by the function and its
+	// callees, Exclusive those run by the function itself.
+	Inclusive, Exclusive int
+}
+
+// Line is the number of instructions run for a line of Jack source.
+type Line struct {
+	project.Location
+	Count int
+}
+
+// Profile is the result of a profiled run.
+type Profile struct {
+	Total     int
+	Functions []*Function // by exclusive count, highest first
+	Lines     []*Line     // by count, highest first
+
+	project *project.Project
+	program *vm.Program
+	samples map[string]*sample
+}
+
+// sample counts the instructions run with the same call stack.
+type sample struct {
+	stack []frame // innermost first
+	count int
+}
+
+// frame is a position in a subroutine: the Jack location of pc, or a
+// builtin when pc is -1.
+type frame struct {
+	function string
+	pc       int
+}
+
+// Run runs the machine to the end, or for at most maxSteps instructions if
+// maxSteps is positive, and profiles it. The machine must run a program
+// linked from p. Run returns the profile so far along with any runtime
+// error.
+func Run(p *project.Project, machine *vm.Machine, maxSteps int) (*Profile, error) {
+	program := machine.Program
+	functions := map[string]*Function{}
+	lines := map[[2]int]*Line{} // by file and line
+	samples := map[string]*sample{}
+
+	locations := make([]project.Location, len(program.Instructions))
+	for pc := range program.Instructions {
+		locations[pc] = p.Location(program, pc)
+	}
+
+	function := func(name string) *Function {
+		if functions[name] == nil {
+			functions[name] = &Function{Name: name}
+		}
+		return functions[name]
+	}
+
+	profile := &Profile{project: p, program: program}
+
+	var err error
+	for steps := 0; !machine.Halted; steps++ {
+		if maxSteps > 0 && steps >= maxSteps {
+			err = vm.ErrStepLimit
+			break
+		}
+
+		pc := machine.PC
+		if pc < 0 || pc >= len(program.Instructions) || len(machine.Frames) == 0 {
+			err = machine.Step()
+			break
+		}
+
+		// the call stack of this instruction, innermost first
+		stack := []frame{}
+		instruction := program.Instructions[pc]
+		if instruction.Command == "call" {
+			function(instruction.Label).Calls++
+
+			if _, defined := program.Functions[instruction.Label]; !defined {
+				stack = append(stack, frame{instruction.Label, -1})
+			}
+		}
+
+		current := machine.Frames[len(machine.Frames)-1]
+		stack = append(stack, frame{current.Function, pc})
+		for i := len(machine.Frames) - 1; i > 0; i-- {
+			stack = append(stack, frame{machine.Frames[i-1].Function, machine.Frames[i].CallPC})
+		}
+
+		profile.Total++
+		function(stack[0].function).Exclusive++
+
+		counted := map[string]bool{}
+		keys := make([]string, len(stack))
+		for i, frame := range stack {
+			if !counted[frame.function] {
+				counted[frame.function] = true
+				function(frame.function).Inclusive++
+			}
+			keys[i] = fmt.Sprintf("%s:%d", frame.function, frame.pc)
+		}
+
+		key := strings.Join(keys, " ")
+		if samples[key] == nil {
+			samples[key] = &sample{stack: stack}
+		}
+		samples[key].count++
+
+		if location := locations[pc]; location.Class != nil {
+			lineKey := [2]int{program.Instructions[pc].File, location.Line}
+			if lines[lineKey] == nil {
+				lines[lineKey] = &Line{Location: location}
+			}
+			lines[lineKey].Count++
+		}
+
+		if err = machine.Step(); err != nil {
+			break
+		}
+	}
+
+	for _, function := range functions {
+		profile.Functions = append(profile.Functions, function)
+	}
+	sort.Slice(profile.Functions, func(i, j int) bool {
+		a, b := profile.Functions[i], profile.Functions[j]
+		if a.Exclusive != b.Exclusive {
+			return a.Exclusive > b.Exclusive
+		}
+		return a.Name < b.Name
+	})
+
+	for _, line := range lines {
+		profile.Lines = append(profile.Lines, line)
+	}
+	sort.Slice(profile.Lines, func(i, j int) bool {
+		a, b := profile.Lines[i], profile.Lines[j]
+		if a.Count != b.Count {
+			return a.Count > b.Count
+		}
+		return a.String() < b.String()
+	})
+
+	profile.samples = samples
+
+	return profile, err
+}
+
+func percent(count, total int) float64 {
+	if total == 0 {
+		return 0
+	}
+
+	return 100 * float64(count) / float64(total)
+}
+
+// WriteText writes the functions and the hottest lines, at most lines of
+// them, as a table.
+func (profile *Profile) WriteText(w io.Writer, lines int) {
+	fmt.Fprintf(w, "%d instructions\n\n", profile.Total)
+
+	fmt.Fprintf(w, "%-32s %8s %16s %16s\n", "function", "calls", "inclusive", "exclusive")
+	for _, function := range profile.Functions {
+		fmt.Fprintf(w, "%-32s %8d %9d %5.1f%% %9d %5.1f%%\n",
+			function.Name, function.Calls,
+			function.Inclusive, percent(function.Inclusive, profile.Total),
+			function.Exclusive, percent(function.Exclusive, profile.Total))
+	}
+
+	fmt.Fprintf(w, "\nhot lines\n")
+	for i, line := range profile.Lines {
+		if i >= lines {
+			break
+		}
+
+		fmt.Fprintf(w, "%9d %5.1f%%  %-20s %s\n",
+			line.Count, percent(line.Count, profile.Total),
+			line.Location, strings.TrimSpace(line.Class.Line(line.Line)))
+	}
+}
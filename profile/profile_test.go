@@ -0,0 +1,175 @@
+package profile
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/uiureo/jack/project"
+	"github.com/uiureo/jack/vm"
+)
+
+func run(t *testing.T) *Profile {
+	p, err := project.Load("fixtures/Fib")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	program, err := p.Program()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	machine, err := vm.New(program)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	profile, err := Run(p, machine, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return profile
+}
+
+func TestRun(t *testing.T) {
+	profile := run(t)
+
+	functions := map[string]*Function{}
+	exclusive := 0
+	for _, function := range profile.Functions {
+		functions[function.Name] = function
+		exclusive += function.Exclusive
+	}
+
+	if exclusive != profile.Total {
+		t.Errorf("expect exclusive counts to add up to %d, got %d", profile.Total, exclusive)
+	}
+
+	// fib(5) calls fib 14 more times
+	if calls := functions["Main.fib"].Calls; calls != 15 {
+		t.Errorf("expect 15 calls of Main.fib, got %d", calls)
+	}
+	if calls := functions["Output.printInt"].Calls; calls != 1 {
+		t.Errorf("expect 1 call of Output.printInt, got %d", calls)
+	}
+	if inclusive := functions["Main.main"].Inclusive; inclusive != profile.Total {
+		t.Errorf("expect Main.main to include all %d instructions, got %d", profile.Total, inclusive)
+	}
+
+	if hot := profile.Lines[0].String(); hot != "Main.jack:8" {
+		t.Errorf("expect the hottest line to be Main.jack:8, got %s", hot)
+	}
+}
+
+func TestWriteText(t *testing.T) {
+	out := &bytes.Buffer{}
+	run(t).WriteText(out, 1)
+
+	for _, expected := range []string{
+		"Main.fib                               15",
+		"Main.jack:8          if (n < 2) {",
+	} {
+		if !strings.Contains(out.String(), expected) {
+			t.Errorf("expect the output to contain `%s`, got:\n%s", expected, out.String())
+		}
+	}
+
+	if strings.Contains(out.String(), "Main.jack:12") {
+		t.Errorf("expect a single hot line, got:\n%s", out.String())
+	}
+}
+
+// uvarint decodes a varint from data and returns it with the rest of data.
+func uvarint(t *testing.T, data []byte) (uint64, []byte) {
+	x, shift := uint64(0), uint(0)
+	for i, b := range data {
+		x |= uint64(b&0x7f) << shift
+		if b < 0x80 {
+			return x, data[i+1:]
+		}
+		shift += 7
+	}
+
+	t.Fatal("truncated varint")
+	return 0, nil
+}
+
+// fields decodes the fields of a protocol buffer message by field number,
+// keeping varints and length-delimited values as raw bytes.
+func fields(t *testing.T, data []byte) map[int][][]byte {
+	result := map[int][][]byte{}
+
+	for len(data) > 0 {
+		var key uint64
+		key, data = uvarint(t, data)
+
+		var value []byte
+		switch key & 7 {
+		case 0:
+			rest := data
+			_, data = uvarint(t, data)
+			value = rest[:len(rest)-len(data)]
+		case 2:
+			var n uint64
+			n, data = uvarint(t, data)
+			if n > uint64(len(data)) {
+				t.Fatal("truncated bytes")
+			}
+			value, data = data[:n], data[n:]
+		default:
+			t.Fatalf("unexpected wire type %d", key&7)
+		}
+
+		result[int(key>>3)] = append(result[int(key>>3)], value)
+	}
+
+	return result
+}
+
+func TestWritePprof(t *testing.T) {
+	profile := run(t)
+
+	out := &bytes.Buffer{}
+	if err := profile.WritePprof(out); err != nil {
+		t.Fatal(err)
+	}
+
+	reader, err := gzip.NewReader(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	message := fields(t, data)
+
+	total := 0
+	for _, sample := range message[2] {
+		// a single packed value
+		value, _ := uvarint(t, fields(t, sample)[2][0])
+		total += int(value)
+	}
+	if total != profile.Total {
+		t.Errorf("expect samples to add up to %d, got %d", profile.Total, total)
+	}
+
+	strs := []string{}
+	for _, s := range message[6] {
+		strs = append(strs, string(s))
+	}
+	for _, expected := range []string{"instructions", "Main.fib", "Main.jack"} {
+		if !strings.Contains(strings.Join(strs, "\n"), expected) {
+			t.Errorf("expect the string table to contain `%s`, got %q", expected, strs)
+		}
+	}
+
+	if len(message[5]) != len(profile.Functions) {
+		t.Errorf("expect %d functions, got %d", len(profile.Functions), len(message[5]))
+	}
+}
@@ -0,0 +1,235 @@
+package project
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/uiureo/jack/compiler"
+	"github.com/uiureo/jack/parser"
+	"github.com/uiureo/jack/tokenizer"
+	"github.com/uiureo/jack/vm"
+)
+
+// Class is a compiled Jack class.
+type Class struct {
+	Name     string
+	JackFile string
+	Source   string
+
+	Tree      *parser.Node
+	VM        string
+	SourceMap *compiler.SourceMap
+
+	// Tables holds the symbol table of each subroutine by VM function name.
+	Tables map[string]*compiler.SymbolTable
+}
+
+// Extensions makes Load accept the Jack language extensions, see
+// tokenizer.TokenizeExt and parser.ParseExt.
+var Extensions = false
+
+// Project is a Jack program: every class of a directory, or a single file.
+type Project struct {
+	Classes []*Class
+}
+
+// Load compiles the Jack files at path, which is either a directory or a
+// single .jack file.
+func Load(path string) (*Project, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	jackFiles := []string{path}
+	if info.IsDir() {
+		jackFiles, _ = filepath.Glob(filepath.Join(path, "*.jack"))
+		if len(jackFiles) == 0 {
+			return nil, fmt.Errorf("%s: no .jack files found", path)
+		}
+		sort.Strings(jackFiles)
+	}
+
+	project := &Project{}
+	for _, jackFile := range jackFiles {
+		data, err := ioutil.ReadFile(jackFile)
+		if err != nil {
+			return nil, err
+		}
+
+		class, err := parseClass(jackFile, string(data))
+		if err != nil {
+			return nil, err
+		}
+
+		project.Classes = append(project.Classes, class)
+	}
+
+	// every class sees the others
+	trees := make([]*parser.Node, len(project.Classes))
+	for i, class := range project.Classes {
+		trees[i] = class.Tree
+	}
+	compiler.DeclareClasses(trees)
+
+	for _, class := range project.Classes {
+		if err := class.compile(); err != nil {
+			return nil, err
+		}
+	}
+
+	if project.usesRuntime() {
+		class, err := parseClass(filepath.Join(filepath.Dir(jackFiles[0]), "Runtime.jack"), compiler.RuntimeSource)
+		if err == nil {
+			err = class.compile()
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		project.Classes = append(project.Classes, class)
+	}
+
+	return project, nil
+}
+
+// usesRuntime reports whether the classes call compiler.RuntimeSource
+// without declaring a Runtime class of their own.
+func (project *Project) usesRuntime() bool {
+	uses := false
+	for _, class := range project.Classes {
+		if class.Name == "Runtime" {
+			return false
+		}
+		uses = uses || compiler.UsesRuntime(class.VM)
+	}
+
+	return uses
+}
+
+func parseClass(jackFile, source string) (class *Class, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%s: %v", jackFile, r)
+		}
+	}()
+
+	var tree *parser.Node
+	if Extensions {
+		tree = parser.ParseExt(tokenizer.TokenizeExt(source))
+	} else {
+		tree = parser.Parse(tokenizer.Tokenize(source))
+	}
+	if tree == nil {
+		return nil, fmt.Errorf("%s: expecting class declaration", jackFile)
+	}
+
+	return &Class{
+		Name:     tree.Children[1].Value,
+		JackFile: jackFile,
+		Source:   source,
+		Tree:     tree,
+	}, nil
+}
+
+func (class *Class) compile() (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%s: %v", class.JackFile, r)
+		}
+	}()
+
+	if errs := compiler.Check(class.Tree); len(errs) > 0 {
+		messages := make([]string, len(errs))
+		for i, err := range errs {
+			messages[i] = fmt.Sprintf("%s:%s", class.JackFile, err)
+		}
+
+		return fmt.Errorf("%s", strings.Join(messages, "\n"))
+	}
+
+	class.VM, class.SourceMap = compiler.CompileWithSourceMap(class.Tree, class.JackFile, false)
+	class.Tables = compiler.SymbolTables(class.Tree)
+
+	return nil
+}
+
+// Location is a position in the Jack source.
+type Location struct {
+	Class        *Class
+	Function     string
+	Line, Column int
+}
+
+func (location Location) String() string {
+	if location.Class == nil {
+		return location.Function
+	}
+
+	return fmt.Sprintf("%s:%d", filepath.Base(location.Class.JackFile), location.Line)
+}
+
+// Location returns the Jack source position of the instruction at pc of a
+// program linked by Program.
+func (project *Project) Location(program *vm.Program, pc int) Location {
+	if pc < 0 || pc >= len(program.Instructions) {
+		return Location{}
+	}
+
+	instruction := program.Instructions[pc]
+	class := project.Classes[instruction.File]
+	location := Location{Class: class}
+
+	if subroutine := class.SourceMap.Subroutine(instruction.Index); subroutine != nil {
+		location.Function = subroutine.Name
+	}
+
+	if mapping := class.SourceMap.Lookup(instruction.Index); mapping != nil {
+		location.Line = mapping.Line
+		location.Column = mapping.Column
+	}
+
+	return location
+}
+
+// Files returns the VM code of the classes in the order of Classes.
+func (project *Project) Files() []vm.File {
+	files := make([]vm.File, len(project.Classes))
+	for i, class := range project.Classes {
+		files[i] = vm.File{Name: class.SourceMap.VMFile, Code: class.VM}
+	}
+
+	return files
+}
+
+// Program links the classes into a VM program. The files of the program are
+// in the order of Classes.
+func (project *Project) Program() (*vm.Program, error) {
+	return vm.NewProgram(project.Files())
+}
+
+// Line returns a line of the class source, numbered from 1.
+func (class *Class) Line(line int) string {
+	lines := strings.Split(class.Source, "\n")
+	if line < 1 || line > len(lines) {
+		return ""
+	}
+
+	return strings.TrimRight(lines[line-1], "\r")
+}
+
+// Subroutine returns the declaration of the subroutine with the VM function
+// name, e.g. `Main.main`.
+func (class *Class) Subroutine(function string) *parser.Node {
+	for _, node := range class.Tree.Children {
+		if node.Name == "subroutineDec" && class.Name+"."+node.Children[2].Value == function {
+			return node
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,88 @@
+package project
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadDirectory(t *testing.T) {
+	p, err := Load("../compiler/fixtures/Square")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	names := []string{}
+	for _, class := range p.Classes {
+		names = append(names, class.Name)
+	}
+
+	if len(names) != 3 || names[0] != "Main" || names[1] != "Square" || names[2] != "SquareGame" {
+		t.Errorf("classes %v, want [Main Square SquareGame]", names)
+	}
+
+	if _, err := p.Program(); err != nil {
+		t.Error(err)
+	}
+
+	if p.Classes[1].Subroutine("Square.moveUp") == nil {
+		t.Error("expect Square.moveUp to be found")
+	}
+}
+
+func TestLoadReportsErrors(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "project")
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "Main.jack")
+	ioutil.WriteFile(file, []byte("class Main {\n  function int main() {\n    return;\n  }\n}\n"), 0644)
+
+	_, err := Load(dir)
+	expected := file + ":3:5: Main.main: subroutine must return a value of type `int`"
+
+	if err == nil || err.Error() != expected {
+		t.Errorf("expect error `%s`, got %v", expected, err)
+	}
+}
+
+func TestLoadSharesConstants(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "project")
+	defer os.RemoveAll(dir)
+
+	ioutil.WriteFile(filepath.Join(dir, "Main.jack"), []byte("class Main {\n  function int main() {\n    return Keys.UP;\n  }\n}\n"), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "Keys.jack"), []byte("class Keys {\n  const int UP = 131;\n}\n"), 0644)
+
+	Extensions = true
+	defer func() { Extensions = false }()
+
+	p, err := Load(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	main := p.Classes[1]
+	if !strings.Contains(main.VM, "push constant 131\nreturn\n") {
+		t.Errorf("expect Keys.UP to be inlined, got:\n%s", main.VM)
+	}
+}
+
+func TestLoadAddsRuntime(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "project")
+	defer os.RemoveAll(dir)
+
+	ioutil.WriteFile(filepath.Join(dir, "Main.jack"), []byte("class Main {\n  function int main() {\n    return 7 % 3;\n  }\n}\n"), 0644)
+
+	Extensions = true
+	defer func() { Extensions = false }()
+
+	p, err := Load(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(p.Classes) != 2 || p.Classes[1].Name != "Runtime" || !strings.Contains(p.Classes[1].VM, "function Runtime.mod 0\n") {
+		t.Fatalf("expect the Runtime class to be added, got %d classes", len(p.Classes))
+	}
+}
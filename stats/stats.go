@@ -0,0 +1,75 @@
+// Package stats measures the static size of the VM code compiled from a Jack
+// program, by class and by subroutine.
+package stats
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/uiureo/jack/project"
+)
+
+// Subroutine is the size of the VM code of a subroutine, from its `function`
+// command to the next one.
+type Subroutine struct {
+	Name         string
+	Instructions int
+}
+
+// Class is the size of the VM code of a class.
+type Class struct {
+	Name         string
+	Instructions int
+	Subroutines  []*Subroutine // in the order of declaration
+}
+
+// Stats is the size of the VM code of a program.
+type Stats struct {
+	Instructions int
+	Classes      []*Class // in the order of Project.Classes
+}
+
+// Count counts the VM commands compiled for each class and subroutine of
+// the project.
+func Count(p *project.Project) (*Stats, error) {
+	program, err := p.Program()
+	if err != nil {
+		return nil, err
+	}
+
+	stats := &Stats{}
+	for _, class := range p.Classes {
+		stats.Classes = append(stats.Classes, &Class{Name: class.Name})
+	}
+
+	var subroutine *Subroutine
+	for _, instruction := range program.Instructions {
+		class := stats.Classes[instruction.File]
+		if instruction.Command == "function" {
+			subroutine = &Subroutine{Name: instruction.Label}
+			class.Subroutines = append(class.Subroutines, subroutine)
+		}
+
+		subroutine.Instructions++
+		class.Instructions++
+		stats.Instructions++
+	}
+
+	return stats, nil
+}
+
+// WriteText writes the counts as a table of classes, each followed by its
+// subroutines.
+func (stats *Stats) WriteText(w io.Writer) {
+	fmt.Fprintf(w, "%-40s %12s\n", "class / subroutine", "instructions")
+	for _, class := range stats.Classes {
+		fmt.Fprintf(w, "%-40s %12d\n", class.Name, class.Instructions)
+		for _, subroutine := range class.Subroutines {
+			name := strings.TrimPrefix(subroutine.Name, class.Name+".")
+			fmt.Fprintf(w, "  %-38s %12d\n", name, subroutine.Instructions)
+		}
+	}
+
+	fmt.Fprintf(w, "%-40s %12d\n", "total", stats.Instructions)
+}
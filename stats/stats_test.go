@@ -0,0 +1,68 @@
+package stats
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/uiureo/jack/project"
+)
+
+func count(t *testing.T, path string) *Stats {
+	p, err := project.Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stats, err := Count(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return stats
+}
+
+func TestCount(t *testing.T) {
+	stats := count(t, "../compiler/fixtures/Square")
+
+	names := []string{}
+	total := 0
+	for _, class := range stats.Classes {
+		names = append(names, class.Name)
+
+		subroutines := 0
+		for _, subroutine := range class.Subroutines {
+			subroutines += subroutine.Instructions
+		}
+		if subroutines != class.Instructions {
+			t.Errorf("%s: expect subroutines to add up to %d, got %d", class.Name, class.Instructions, subroutines)
+		}
+		total += class.Instructions
+	}
+
+	if strings.Join(names, " ") != "Main Square SquareGame" {
+		t.Errorf("unexpected classes: %v", names)
+	}
+	if total != stats.Instructions {
+		t.Errorf("expect classes to add up to %d, got %d", stats.Instructions, total)
+	}
+
+	game := stats.Classes[2]
+	if game.Subroutines[0].Name != "SquareGame.new" {
+		t.Errorf("expect subroutines in the order of declaration, got %s first", game.Subroutines[0].Name)
+	}
+}
+
+func TestWriteText(t *testing.T) {
+	out := &bytes.Buffer{}
+	count(t, "../compiler/fixtures/Seven").WriteText(out)
+
+	expected := `class / subroutine                       instructions
+Main                                               10
+  main                                             10
+total                                              10
+`
+	if out.String() != expected {
+		t.Errorf("expect:\n%s\ngot:\n%s", expected, out.String())
+	}
+}
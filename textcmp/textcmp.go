@@ -0,0 +1,163 @@
+// Package textcmp compares text files like the nand2tetris TextComparer:
+// whitespace within lines and blank lines are ignored.
+package textcmp
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+const context = 3
+
+type line struct {
+	number     int // 1-based line number in the original text
+	text       string
+	normalized string
+}
+
+func split(text string) []line {
+	lines := []line{}
+
+	for i, text := range strings.Split(text, "\n") {
+		normalized := strings.Map(func(r rune) rune {
+			if unicode.IsSpace(r) {
+				return -1
+			}
+			return r
+		}, text)
+
+		if len(normalized) > 0 {
+			lines = append(lines, line{i + 1, strings.TrimRight(text, "\r"), normalized})
+		}
+	}
+
+	return lines
+}
+
+// Equal reports whether a and b have the same lines once whitespace is
+// removed.
+func Equal(a, b string) bool {
+	linesA, linesB := split(a), split(b)
+	if len(linesA) != len(linesB) {
+		return false
+	}
+
+	for i := range linesA {
+		if linesA[i].normalized != linesB[i].normalized {
+			return false
+		}
+	}
+
+	return true
+}
+
+type edit struct {
+	op               byte // ' ', '-' or '+'
+	text             string
+	numberA, numberB int
+}
+
+// Diff returns a unified diff from a to b ignoring whitespace, or "" if they
+// are equal. Hunk headers refer to the line numbers of the original texts.
+func Diff(nameA, nameB, a, b string) string {
+	if Equal(a, b) {
+		return ""
+	}
+
+	edits := diffLines(split(a), split(b))
+
+	result := fmt.Sprintf("--- %s\n+++ %s\n", nameA, nameB)
+	for start := 0; start < len(edits); {
+		if edits[start].op == ' ' {
+			start++
+			continue
+		}
+
+		// extend the hunk while changes are separated by at most
+		// 2 * context unchanged lines
+		end := start
+		for i := start; i < len(edits) && i-end <= 2*context; i++ {
+			if edits[i].op != ' ' {
+				end = i + 1
+			}
+		}
+
+		from, to := start-context, end+context
+		if from < 0 {
+			from = 0
+		}
+		if to > len(edits) {
+			to = len(edits)
+		}
+
+		result += hunk(edits[from:to])
+		start = to
+	}
+
+	return result
+}
+
+func hunk(edits []edit) string {
+	startA, startB, countA, countB := 0, 0, 0, 0
+	body := ""
+
+	for _, e := range edits {
+		if e.op != '+' {
+			if countA == 0 {
+				startA = e.numberA
+			}
+			countA++
+		}
+		if e.op != '-' {
+			if countB == 0 {
+				startB = e.numberB
+			}
+			countB++
+		}
+
+		body += string(e.op) + e.text + "\n"
+	}
+
+	return fmt.Sprintf("@@ -%d,%d +%d,%d @@\n", startA, countA, startB, countB) + body
+}
+
+// diffLines computes a shortest edit script through the longest common
+// subsequence of normalized lines.
+func diffLines(a, b []line) []edit {
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i].normalized == b[j].normalized {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	edits := []edit{}
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i].normalized == b[j].normalized:
+			edits = append(edits, edit{' ', a[i].text, a[i].number, b[j].number})
+			i++
+			j++
+		case j == len(b) || (i < len(a) && lcs[i+1][j] >= lcs[i][j+1]):
+			edits = append(edits, edit{'-', a[i].text, a[i].number, 0})
+			i++
+		default:
+			edits = append(edits, edit{'+', b[j].text, 0, b[j].number})
+			j++
+		}
+	}
+
+	return edits
+}
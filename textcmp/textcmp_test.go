@@ -0,0 +1,49 @@
+package textcmp
+
+import "testing"
+
+func TestEqual(t *testing.T) {
+	if !Equal("<tokens>\r\n  <symbol> { </symbol>\r\n\r\n</tokens>\r\n", "<tokens>\n<symbol>{</symbol>\n</tokens>") {
+		t.Error("expect texts differing in whitespace to be equal")
+	}
+
+	if Equal("<a> b c </a>", "<a> b </a>\n<a> c </a>") {
+		t.Error("expect line breaks to matter")
+	}
+
+	if Equal("a\nb", "a") {
+		t.Error("expect missing lines to matter")
+	}
+}
+
+func TestDiff(t *testing.T) {
+	a := "1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n11\n12\n13\n14\n15\n16\n"
+	b := "1\n2\n3\n4\n  5\nfive\n6\n7\n8\n9\n10\n11\n12\n13\n15\n16\n"
+
+	expected := `--- got
++++ want
+@@ -3,6 +3,7 @@
+ 3
+ 4
+ 5
++five
+ 6
+ 7
+ 8
+@@ -11,6 +12,5 @@
+ 11
+ 12
+ 13
+-14
+ 15
+ 16
+`
+
+	if diff := Diff("got", "want", a, b); diff != expected {
+		t.Errorf("expect:\n%s\ngot:\n%s", expected, diff)
+	}
+
+	if diff := Diff("got", "want", a, a); diff != "" {
+		t.Errorf("expect no diff, got:\n%s", diff)
+	}
+}
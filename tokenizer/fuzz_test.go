@@ -0,0 +1,46 @@
+package tokenizer
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func addSeeds(f *testing.F) {
+	for _, pattern := range []string{"../fixtures/*.jack", "../compiler/fixtures/*/*.jack"} {
+		files, _ := filepath.Glob(pattern)
+		for _, file := range files {
+			data, err := ioutil.ReadFile(file)
+			if err != nil {
+				f.Fatal(err)
+			}
+			f.Add(string(data))
+		}
+	}
+}
+
+func FuzzTokenize(f *testing.F) {
+	addSeeds(f)
+
+	f.Fuzz(func(t *testing.T, source string) {
+		lines := strings.Split(source, "\n")
+
+		for _, token := range Tokenize(source) {
+			if token.TokenType == "" {
+				t.Fatalf("%d:%d: token `%s` has no type", token.Line, token.Column, token.Value)
+			}
+
+			// the token must be found at its position in the source
+			text := token.Value
+			if token.TokenType == "stringConstant" {
+				text = `"` + text + `"`
+			}
+
+			if token.Line < 1 || token.Line > len(lines) || token.Column < 1 ||
+				!strings.HasPrefix(lines[token.Line-1][token.Column-1:], text) {
+				t.Fatalf("%d:%d: token `%s` is not at its position", token.Line, token.Column, token.Value)
+			}
+		}
+	})
+}
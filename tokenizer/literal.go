@@ -0,0 +1,92 @@
+package tokenizer
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Characters of the Hack character set outside of ASCII.
+const (
+	newLine   = 128
+	backSpace = 129
+)
+
+// escapes maps the escape sequences of string and character literals to the
+// Hack character set.
+var escapes = map[byte]rune{
+	'n':  newLine,
+	'b':  backSpace,
+	'"':  '"',
+	'\'': '\'',
+	'\\': '\\',
+}
+
+// decodeLiteral returns the value of a literal of TokenizeExt: the decimal
+// value of an integer or character literal, or the characters of a string
+// constant without its quotes.
+func decodeLiteral(tokenType, value string) string {
+	switch tokenType {
+	case "stringConstant":
+		return unescape(value)
+
+	case "integerConstant":
+		var n int64
+		var err error
+
+		switch {
+		case strings.HasPrefix(value, "'"):
+			chars := []rune(unescape(value[1 : len(value)-1]))
+			n = int64(chars[0])
+		case strings.HasPrefix(value, "0x"), strings.HasPrefix(value, "0X"):
+			n, err = strconv.ParseInt(value[2:], 16, 64)
+		case strings.HasPrefix(value, "0b"), strings.HasPrefix(value, "0B"):
+			n, err = strconv.ParseInt(value[2:], 2, 64)
+		default:
+			n, err = strconv.ParseInt(value, 10, 64)
+		}
+
+		if err != nil || n > 32767 {
+			panic(fmt.Sprintf("integer constant `%s` is out of range", value))
+		}
+
+		return strconv.FormatInt(n, 10)
+	}
+
+	return value
+}
+
+// unescape replaces the escape sequences of str with their characters,
+// which must be in the Hack character set.
+func unescape(str string) string {
+	result := []rune{}
+
+	for i := 0; i < len(str); {
+		if str[i] != '\\' {
+			r, size := utf8.DecodeRuneInString(str[i:])
+			if !isHackChar(r) {
+				panic(fmt.Sprintf("character `%c` (%U) is not in the Hack character set", r, r))
+			}
+			result = append(result, r)
+			i += size
+			continue
+		}
+
+		r, ok := escapes[str[i+1]]
+		if !ok {
+			escaped, _ := utf8.DecodeRuneInString(str[i+1:])
+			panic(fmt.Sprintf("unknown escape sequence `\\%c`", escaped))
+		}
+		result = append(result, r)
+		i += 2
+	}
+
+	return string(result)
+}
+
+// isHackChar reports whether a character of the source is in the Hack
+// character set: printable ASCII, and 128 to 152 for the keys.
+func isHackChar(r rune) bool {
+	return (r >= 32 && r <= 126) || (r >= newLine && r <= 152)
+}
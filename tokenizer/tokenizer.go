@@ -1,6 +1,7 @@
 package tokenizer
 
 import (
+	"fmt"
 	"regexp"
 	"strings"
 )
@@ -29,6 +30,40 @@ var keywords = []string{
 	"return",
 }
 
+// extKeywords and extSymbols are the tokens of the language extensions,
+// which only TokenizeExt recognizes.
+var extKeywords = []string{
+	"for",
+	"break",
+	"continue",
+	"switch",
+	"case",
+	"default",
+	"const",
+	"enum",
+	"extends",
+	"interface",
+	"implements",
+}
+
+var extSymbols = []string{
+	"+=",
+	"-=",
+	"++",
+	"--",
+	"&&",
+	"||",
+	"!=",
+	"<=",
+	">=",
+	"<<",
+	">>",
+	"%",
+	"^",
+	":",
+	"?",
+}
+
 var symbols = []string{
 	"{",
 	"}",
@@ -54,6 +89,11 @@ var symbols = []string{
 type Token struct {
 	TokenType string
 	Value     string
+
+	// Line and Column are the 1-based position of the token in the source,
+	// and Width its length in bytes, which differs from the length of Value
+	// for string constants and for the literals of TokenizeExt.
+	Line, Column, Width int
 }
 
 func (token *Token) IsOp() bool {
@@ -64,11 +104,19 @@ func (token *Token) IsOp() bool {
 	switch token.Value {
 	case "+", "-", "*", "/", "&", "|", "<", ">", "=":
 		return true
+	case "&&", "||", "!=", "<=", ">=", "%", "<<", ">>", "^":
+		return true
 	default:
 		return false
 	}
 }
 
+// IsCompoundAssignment reports whether the token is `+=`, `-=`, `++` or `--`.
+func (token *Token) IsCompoundAssignment() bool {
+	return token.TokenType == "symbol" &&
+		(token.Value == "+=" || token.Value == "-=" || token.Value == "++" || token.Value == "--")
+}
+
 func (token *Token) IsUnaryOp() bool {
 	return token.TokenType == "symbol" && (token.Value == "-" || token.Value == "~")
 }
@@ -97,11 +145,29 @@ func (token *Token) IsKeywordConstant() bool {
 	}
 }
 
-func Tokenize(source string) []*Token {
-	source = removeComment(source)
+var xmlEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;", `"`, "&quot;")
+
+// ToXML renders tokens in the format of the nand2tetris tokenizer test files
+// such as MainT.xml.
+func ToXML(tokens []*Token) string {
+	result := "<tokens>\n"
+
+	for _, token := range tokens {
+		result += fmt.Sprintf("<%s> %s </%s>\n", token.TokenType, xmlEscaper.Replace(token.Value), token.TokenType)
+	}
+
+	return result + "</tokens>\n"
+}
 
-	tokenRegexpMap := buildTokenRegexpMap()
-	tokenRegexp := regexp.MustCompile(
+var (
+	tokenRegexp    = buildTokenRegexp(false)
+	extTokenRegexp = buildTokenRegexp(true)
+)
+
+func buildTokenRegexp(ext bool) *regexp.Regexp {
+	tokenRegexpMap := buildTokenRegexpMap(ext)
+
+	return regexp.MustCompile(
 		strings.Join([]string{
 			tokenRegexpMap["symbol"],
 			tokenRegexpMap["integerConstant"],
@@ -109,32 +175,92 @@ func Tokenize(source string) []*Token {
 			tokenRegexpMap["identifier"],
 		}, "|"),
 	)
+}
+
+func Tokenize(source string) []*Token {
+	return tokenize(source, false)
+}
 
-	tokenValues := tokenRegexp.FindAllString(source, -1)
+// TokenizeExt tokenizes source written with the Jack language extensions,
+// such as the `for` loop. Character, hexadecimal and binary literals become
+// integerConstant tokens of their decimal value, and the escape sequences of
+// string constants, such as \n, are replaced with their characters in the
+// Hack character set. It panics with a message on a literal out of range or
+// an unknown escape sequence.
+func TokenizeExt(source string) []*Token {
+	return tokenize(source, true)
+}
+
+func tokenize(source string, ext bool) []*Token {
+	tokenRegexp, tokenTypeRegexps, commentRegexp := tokenRegexp, tokenTypeRegexps, commentRegexp
+	if ext {
+		tokenRegexp, tokenTypeRegexps, commentRegexp = extTokenRegexp, extTokenTypeRegexps, extCommentRegexp
+	}
+
+	source = removeComment(source, commentRegexp)
+
+	locations := tokenRegexp.FindAllStringIndex(source, -1)
+
+	tokens := make([]*Token, len(locations))
+	line, lineStart, offset := 1, 0, 0
+	for i, location := range locations {
+		for ; offset < location[0]; offset++ {
+			if source[offset] == '\n' {
+				line++
+				lineStart = offset + 1
+			}
+		}
 
-	tokens := make([]*Token, len(tokenValues))
-	for i, tokenValue := range tokenValues {
-		tokenType := detectTokenType(tokenValue)
+		tokenValue := source[location[0]:location[1]]
+		tokenType := detectTokenType(tokenValue, tokenTypeRegexps)
 		if tokenType == "stringConstant" {
-			tokenValue = strings.Trim(tokenValue, `"`)
+			tokenValue = tokenValue[1 : len(tokenValue)-1]
 		}
 
-		tokens[i] = &Token{TokenType: tokenType, Value: tokenValue}
+		if ext {
+			tokenValue = decodeLiteral(tokenType, tokenValue)
+		}
+
+		tokens[i] = &Token{
+			TokenType: tokenType,
+			Value:     tokenValue,
+			Line:      line,
+			Column:    location[0] - lineStart + 1,
+			Width:     location[1] - location[0],
+		}
 	}
 
 	return tokens
 }
 
-func buildTokenRegexpMap() map[string]string {
-	return map[string]string{
+func buildTokenRegexpMap(ext bool) map[string]string {
+	keywords, symbols := keywords, symbols
+	if ext {
+		keywords = append(append([]string{}, keywords...), extKeywords...)
+		// longer symbols first, as the leftmost alternative wins
+		symbols = append(append([]string{}, extSymbols...), symbols...)
+	}
+
+	regexps := map[string]string{
 		"keyword":         buildRegexpFromList(keywords),
 		"symbol":          buildRegexpFromList(symbols),
 		"integerConstant": `\d+`,
 		"stringConstant":  `"[^"\n]+"`,
 		"identifier":      `[a-zA-Z_]\w*`,
 	}
+
+	if ext {
+		regexps["integerConstant"] = charConstant + `|0[xX][0-9a-fA-F]+|0[bB][01]+|\d+`
+		regexps["stringConstant"] = `"(?:[^"\\\n]|\\.)*"`
+	}
+
+	return regexps
 }
 
+// charConstant matches the character literals of TokenizeExt, such as 'A'
+// and '\n'.
+const charConstant = `'(?:[^'\\\n]|\\.)'`
+
 var tokenTypes = []string{
 	"keyword",
 	"symbol",
@@ -143,12 +269,24 @@ var tokenTypes = []string{
 	"identifier",
 }
 
-func detectTokenType(token string) string {
-	regexpMap := buildTokenRegexpMap()
+// tokenTypeRegexps match a whole token of each type.
+var (
+	tokenTypeRegexps    = buildTokenTypeRegexps(false)
+	extTokenTypeRegexps = buildTokenTypeRegexps(true)
+)
+
+func buildTokenTypeRegexps(ext bool) map[string]*regexp.Regexp {
+	regexps := map[string]*regexp.Regexp{}
+	for tokenType, regexpString := range buildTokenRegexpMap(ext) {
+		regexps[tokenType] = regexp.MustCompile(`^(` + regexpString + `)$`)
+	}
+
+	return regexps
+}
+
+func detectTokenType(token string, tokenTypeRegexps map[string]*regexp.Regexp) string {
 	for _, tokenType := range tokenTypes {
-		regexpString := regexpMap[tokenType]
-		matched := regexp.MustCompile(`^(` + regexpString + `)$`).MatchString(token)
-		if matched {
+		if tokenTypeRegexps[tokenType].MatchString(token) {
 			return tokenType
 		}
 	}
@@ -165,8 +303,44 @@ func buildRegexpFromList(strs []string) string {
 	return strings.Join(escaped, "|")
 }
 
-func removeComment(str string) string {
-	str = regexp.MustCompile(`(?m)\s*//.+$`).ReplaceAllString(str, "")
-	str = regexp.MustCompile(`(?ms)/\*.*?\*/`).ReplaceAllString(str, "")
-	return str
+// commentRegexp matches comments, and string constants so that comment
+// markers inside strings are left alone.
+var (
+	commentRegexp    = buildCommentRegexp(false)
+	extCommentRegexp = buildCommentRegexp(true)
+)
+
+func buildCommentRegexp(ext bool) *regexp.Regexp {
+	literals := buildTokenRegexpMap(ext)["stringConstant"]
+	if ext {
+		// the quote of '"' doesn't start a string
+		literals = charConstant + "|" + literals
+	}
+
+	return regexp.MustCompile(literals + `|//[^\n]*|(?s:/\*.*?\*/)`)
+}
+
+// removeComment blanks out comments, keeping newlines so that token
+// positions still point into the original source.
+func removeComment(str string, commentRegexp *regexp.Regexp) string {
+	return commentRegexp.ReplaceAllStringFunc(str, func(match string) string {
+		if strings.HasPrefix(match, `"`) || strings.HasPrefix(match, "'") {
+			return match
+		}
+
+		return blank(match)
+	})
+}
+
+// blank replaces every byte but newlines with a space, keeping byte offsets
+// even for multibyte characters.
+func blank(str string) string {
+	blanked := []byte(str)
+	for i, b := range blanked {
+		if b != '\n' {
+			blanked[i] = ' '
+		}
+	}
+
+	return string(blanked)
 }
@@ -97,3 +97,182 @@ let foo=0; // foo bar
 		}
 	}
 }
+
+func TestTokenizePosition(t *testing.T) {
+	tokens := Tokenize(`/* comment
+ */ let x = 1; // comment
+	do Foo.bar("a");`)
+
+	expected := [][]int{
+		{2, 5}, {2, 9}, {2, 11}, {2, 13}, {2, 14},
+		{3, 2}, {3, 5}, {3, 8}, {3, 9}, {3, 12}, {3, 13}, {3, 16}, {3, 17},
+	}
+
+	if len(tokens) != len(expected) {
+		t.Fatalf("expect length: %d, got %d", len(expected), len(tokens))
+	}
+
+	for i, token := range tokens {
+		if token.Line != expected[i][0] || token.Column != expected[i][1] {
+			t.Errorf("`%s`: expect %d:%d, got %d:%d", token.Value, expected[i][0], expected[i][1], token.Line, token.Column)
+		}
+	}
+}
+
+func TestToXML(t *testing.T) {
+	expected := `<tokens>
+<keyword> if </keyword>
+<symbol> ( </symbol>
+<identifier> x </identifier>
+<symbol> &lt; </symbol>
+<integerConstant> 0 </integerConstant>
+<symbol> &amp; </symbol>
+<stringConstant> a &gt; b </stringConstant>
+<symbol> ) </symbol>
+</tokens>
+`
+
+	actual := ToXML(Tokenize(`if (x < 0 & "a > b")`))
+
+	if actual != expected {
+		t.Errorf("expect:\n%s\ngot:\n%s", expected, actual)
+	}
+}
+
+func TestTokenizeCommentMarkersInString(t *testing.T) {
+	tokens := Tokenize(`let url = "http://a/*b*/"; // "comment"
+/* "also a comment" */ let s = "é//";`)
+
+	testTokensMatch(t, tokens, [][]string{
+		{"let", "keyword"},
+		{"url", "identifier"},
+		{"=", "symbol"},
+		{"http://a/*b*/", "stringConstant"},
+		{";", "symbol"},
+		{"let", "keyword"},
+		{"s", "identifier"},
+		{"=", "symbol"},
+		{"é//", "stringConstant"},
+		{";", "symbol"},
+	})
+
+	if token := tokens[5]; token.Line != 2 || token.Column != 24 {
+		t.Errorf("expect `let` at 2:24, got %d:%d", token.Line, token.Column)
+	}
+}
+
+func TestTokenizeExt(t *testing.T) {
+	source := `for (let i = 0; i < n; let i = i + 1) { break; continue; }`
+
+	if token := Tokenize(source)[0]; token.TokenType != "identifier" {
+		t.Errorf("expect `for` to be an identifier in plain Jack, got %s", token.TokenType)
+	}
+
+	tokens := TokenizeExt(source)
+	for _, i := range []int{0, 19, 21} {
+		if tokens[i].TokenType != "keyword" {
+			t.Errorf("expect `%s` to be a keyword, got %s", tokens[i].Value, tokens[i].TokenType)
+		}
+	}
+}
+
+func TestTokenizeExtOperators(t *testing.T) {
+	testTokensMatch(t, TokenizeExt(`a%b<<c>>d^e<f`), [][]string{
+		{"a", "identifier"},
+		{"%", "symbol"},
+		{"b", "identifier"},
+		{"<<", "symbol"},
+		{"c", "identifier"},
+		{">>", "symbol"},
+		{"d", "identifier"},
+		{"^", "symbol"},
+		{"e", "identifier"},
+		{"<", "symbol"},
+		{"f", "identifier"},
+	})
+
+	testTokensMatch(t, TokenizeExt(`a&&b||c!=d<=e>=f&g`), [][]string{
+		{"a", "identifier"},
+		{"&&", "symbol"},
+		{"b", "identifier"},
+		{"||", "symbol"},
+		{"c", "identifier"},
+		{"!=", "symbol"},
+		{"d", "identifier"},
+		{"<=", "symbol"},
+		{"e", "identifier"},
+		{">=", "symbol"},
+		{"f", "identifier"},
+		{"&", "symbol"},
+		{"g", "identifier"},
+	})
+}
+
+func TestTokenizeExtAssignments(t *testing.T) {
+	testTokensMatch(t, TokenizeExt(`a+=b-=c++--d+e`), [][]string{
+		{"a", "identifier"},
+		{"+=", "symbol"},
+		{"b", "identifier"},
+		{"-=", "symbol"},
+		{"c", "identifier"},
+		{"++", "symbol"},
+		{"--", "symbol"},
+		{"d", "identifier"},
+		{"+", "symbol"},
+		{"e", "identifier"},
+	})
+}
+
+func TestTokenizeExtLiterals(t *testing.T) {
+	tokens := TokenizeExt(`'A' '\n' '"' 0x1F 0B1010 32767 "" "a\"b\\c\n" "// not a comment" '\''`)
+
+	testTokensMatch(t, tokens, [][]string{
+		{"65", "integerConstant"},
+		{"128", "integerConstant"},
+		{"34", "integerConstant"},
+		{"31", "integerConstant"},
+		{"10", "integerConstant"},
+		{"32767", "integerConstant"},
+		{"", "stringConstant"},
+		{"a\"b\\c\u0080", "stringConstant"},
+		{"// not a comment", "stringConstant"},
+		{"39", "integerConstant"},
+	})
+
+	if tokens[3].Column != 14 || tokens[3].Width != 4 {
+		t.Errorf("expect `0x1F` at column 14 with width 4, got %d and %d", tokens[3].Column, tokens[3].Width)
+	}
+	if tokens[7].Width != 11 {
+		t.Errorf("expect the escaped string to have width 11, got %d", tokens[7].Width)
+	}
+}
+
+func TestTokenizeExtLiteralErrors(t *testing.T) {
+	tests := map[string]string{
+		`0x8000`:   "integer constant `0x8000` is out of range",
+		`32768`:    "integer constant `32768` is out of range",
+		`'😀'`:      "character `😀` (U+1F600) is not in the Hack character set",
+		`'é'`:      "character `é` (U+00E9) is not in the Hack character set",
+		`"café"`:   "character `é` (U+00E9) is not in the Hack character set",
+		"\"a\tb\"": "character `\t` (U+0009) is not in the Hack character set",
+		`"a\tb"`:   "unknown escape sequence `\\t`",
+		`'\é'`:     "unknown escape sequence `\\é`",
+		`0b1 0x10`: "",
+	}
+
+	for source, message := range tests {
+		func() {
+			defer func() {
+				r := recover()
+				if message == "" && r != nil {
+					t.Errorf("%s: unexpected error: %v", source, r)
+				}
+				if message != "" && r != message {
+					t.Errorf("%s: expect error `%s`, got %v", source, message, r)
+				}
+			}()
+
+			TokenizeExt(source)
+		}()
+	}
+}
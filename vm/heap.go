@@ -0,0 +1,82 @@
+package vm
+
+import "sort"
+
+// heap is the allocator behind Memory.alloc and Memory.deAlloc. It keeps its
+// bookkeeping outside of RAM, so programs can't corrupt it.
+type heap struct {
+	blocks map[int]int // base address -> size of allocated blocks
+	free   []span      // sorted by start
+}
+
+type span struct {
+	start, size int
+}
+
+func newHeap() *heap {
+	return &heap{
+		blocks: map[int]int{},
+		free:   []span{{HeapBase, ScreenBase - HeapBase}},
+	}
+}
+
+// alloc returns the base address of a block of size words using first fit,
+// or false if no free span is large enough.
+func (heap *heap) alloc(size int) (int, bool) {
+	for i, span := range heap.free {
+		if span.size < size {
+			continue
+		}
+
+		base := span.start
+		if span.size == size {
+			heap.free = append(heap.free[:i], heap.free[i+1:]...)
+		} else {
+			heap.free[i].start += size
+			heap.free[i].size -= size
+		}
+
+		heap.blocks[base] = size
+		return base, true
+	}
+
+	return 0, false
+}
+
+// deAlloc frees the block at base, or returns false if base isn't the base
+// address of an allocated block.
+func (heap *heap) deAlloc(base int) bool {
+	size, ok := heap.blocks[base]
+	if !ok {
+		return false
+	}
+	delete(heap.blocks, base)
+
+	i := sort.Search(len(heap.free), func(i int) bool { return heap.free[i].start > base })
+	heap.free = append(heap.free, span{})
+	copy(heap.free[i+1:], heap.free[i:])
+	heap.free[i] = span{base, size}
+
+	// merge with the following and the preceding span
+	if i+1 < len(heap.free) && heap.free[i].start+heap.free[i].size == heap.free[i+1].start {
+		heap.free[i].size += heap.free[i+1].size
+		heap.free = append(heap.free[:i+1], heap.free[i+2:]...)
+	}
+	if i > 0 && heap.free[i-1].start+heap.free[i-1].size == heap.free[i].start {
+		heap.free[i-1].size += heap.free[i].size
+		heap.free = append(heap.free[:i], heap.free[i+1:]...)
+	}
+
+	return true
+}
+
+// block returns the base and size of the allocated block containing address.
+func (heap *heap) block(address int) (int, int, bool) {
+	for base, size := range heap.blocks {
+		if base <= address && address < base+size {
+			return base, size, true
+		}
+	}
+
+	return 0, 0, false
+}
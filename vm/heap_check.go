@@ -0,0 +1,150 @@
+package vm
+
+import "sort"
+
+// Kinds of heap issues.
+const (
+	Leak           = "leak"
+	DoubleDeAlloc  = "double deAlloc"
+	InvalidDeAlloc = "invalid deAlloc"
+	UseAfterFree   = "use after free"
+)
+
+// Allocation is a heap block allocated by the program.
+type Allocation struct {
+	Base, Size int
+
+	// PC is the call to the allocating OS function and Stack holds the
+	// calls of the active subroutines at that time, innermost first.
+	PC    int
+	Stack []int
+
+	// FreedPC is the call that freed the block, or -1.
+	FreedPC int
+}
+
+// HeapIssue is a misuse of the heap found by the heap check.
+type HeapIssue struct {
+	Kind string
+
+	// PC is where the issue occurred, with the calls of the active
+	// subroutines in Stack. Leaks have neither.
+	PC    int
+	Stack []int
+
+	// Address is the accessed address for UseAfterFree and the freed
+	// address for deAlloc issues.
+	Address int
+
+	// Allocation is the block concerned, if any.
+	Allocation *Allocation
+}
+
+// heapCheck tracks the blocks of the heap for Machine.CheckHeap.
+type heapCheck struct {
+	live   map[int]*Allocation
+	freed  []*Allocation // not reused yet
+	issues []*HeapIssue
+
+	reported map[[2]int]bool // use after free by pc and block
+}
+
+// CheckHeap makes the machine track heap blocks, so that HeapIssues reports
+// double or invalid deAlloc calls, and reads and writes through `this` and
+// `that` into freed blocks.
+func (machine *Machine) CheckHeap() {
+	machine.heapCheck = &heapCheck{
+		live:     map[int]*Allocation{},
+		reported: map[[2]int]bool{},
+	}
+}
+
+// HeapIssues returns the issues found since CheckHeap, followed by a Leak
+// for every block that is still allocated.
+func (machine *Machine) HeapIssues() []*HeapIssue {
+	check := machine.heapCheck
+	if check == nil {
+		return nil
+	}
+
+	issues := append([]*HeapIssue{}, check.issues...)
+
+	bases := []int{}
+	for base := range check.live {
+		bases = append(bases, base)
+	}
+	sort.Ints(bases)
+
+	for _, base := range bases {
+		issues = append(issues, &HeapIssue{Kind: Leak, PC: -1, Address: base, Allocation: check.live[base]})
+	}
+
+	return issues
+}
+
+// callStack returns the calls of the active subroutines, innermost first.
+func (machine *Machine) callStack() []int {
+	stack := []int{}
+	for i := len(machine.Frames) - 1; i >= 0; i-- {
+		if machine.Frames[i].CallPC >= 0 {
+			stack = append(stack, machine.Frames[i].CallPC)
+		}
+	}
+
+	return stack
+}
+
+func (check *heapCheck) alloc(machine *Machine, base, size int) {
+	allocation := &Allocation{Base: base, Size: size, PC: machine.PC, Stack: machine.callStack(), FreedPC: -1}
+	check.live[base] = allocation
+
+	// freed blocks overlapping the new one are no longer tracked
+	freed := check.freed[:0]
+	for _, block := range check.freed {
+		if block.Base+block.Size <= base || base+size <= block.Base {
+			freed = append(freed, block)
+		}
+	}
+	check.freed = freed
+}
+
+func (check *heapCheck) deAlloc(machine *Machine, base int) {
+	if allocation, ok := check.live[base]; ok {
+		delete(check.live, base)
+		allocation.FreedPC = machine.PC
+		check.freed = append(check.freed, allocation)
+		return
+	}
+
+	issue := &HeapIssue{Kind: InvalidDeAlloc, PC: machine.PC, Stack: machine.callStack(), Address: base}
+	for _, block := range check.freed {
+		if block.Base == base {
+			issue.Kind = DoubleDeAlloc
+			issue.Allocation = block
+		}
+	}
+
+	check.issues = append(check.issues, issue)
+}
+
+// access checks a read or write of address through `this` or `that`.
+func (check *heapCheck) access(machine *Machine, address int) {
+	for _, block := range check.freed {
+		if block.Base <= address && address < block.Base+block.Size {
+			key := [2]int{machine.PC, block.Base}
+			if check.reported[key] {
+				return
+			}
+			check.reported[key] = true
+
+			check.issues = append(check.issues, &HeapIssue{
+				Kind:       UseAfterFree,
+				PC:         machine.PC,
+				Stack:      machine.callStack(),
+				Address:    address,
+				Allocation: block,
+			})
+			return
+		}
+	}
+}
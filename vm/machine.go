@@ -0,0 +1,430 @@
+package vm
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+)
+
+// Memory map of the Hack platform.
+const (
+	SP   = 0
+	LCL  = 1
+	ARG  = 2
+	THIS = 3
+	THAT = 4
+
+	TempBase   = 5
+	StackBase  = 256
+	HeapBase   = 2048
+	ScreenBase = 16384
+	KeyboardIn = 24576
+	RAMSize    = 24577
+)
+
+// ErrStepLimit is returned by Run when the program doesn't halt in time.
+var ErrStepLimit = errors.New("step limit exceeded")
+
+// Frame is a subroutine activation on the call stack.
+type Frame struct {
+	Function string
+
+	// CallPC is the address of the `call` instruction, ReturnPC is where
+	// execution continues after return, or -1 for the bootstrap frame.
+	CallPC, ReturnPC int
+
+	// LCL and ARG are the bases of the local and argument segments.
+	LCL, ARG int
+}
+
+// Builtin implements a VM function in Go, e.g. the OS classes.
+type Builtin func(machine *Machine, args []int16) (int16, error)
+
+// RuntimeError is an error raised while the program runs.
+type RuntimeError struct {
+	PC      int
+	Message string
+}
+
+func (err *RuntimeError) Error() string {
+	return err.Message
+}
+
+// Machine executes a Program against the Hack memory map. Calls to functions
+// the program doesn't define go to Builtins, which provide the Jack OS.
+type Machine struct {
+	RAM     [RAMSize]int16
+	PC      int
+	Program *Program
+	Frames  []*Frame
+
+	Builtins map[string]Builtin
+
+	// Output receives the text printed with Output.*.
+	Output io.Writer
+
+	// Keyboard is the scripted keyboard input. Keyboard.keyPressed consumes
+	// one entry per call, where 0 means no key is pressed, and the read
+	// functions consume keys up to the next newline (128).
+	Keyboard []int16
+
+	Halted bool
+	Steps  int
+
+	// arities are the numbers of arguments of the OS builtins.
+	arities map[string]int
+
+	heap      *heap
+	heapCheck *heapCheck
+	screen    struct {
+		color bool
+	}
+}
+
+// New creates a machine that starts at Sys.init if the program defines it,
+// or else at Main.main with the OS initialized.
+func New(program *Program) (*Machine, error) {
+	entry := "Sys.init"
+	if _, ok := program.Functions[entry]; !ok {
+		entry = "Main.main"
+	}
+
+	return NewCall(program, entry)
+}
+
+// NewCall creates a machine that starts by calling function without
+// arguments, and halts when it returns.
+func NewCall(program *Program, function string) (*Machine, error) {
+	machine := &Machine{
+		Program:  program,
+		Builtins: map[string]Builtin{},
+		Output:   ioutil.Discard,
+		arities:  map[string]int{},
+		heap:     newHeap(),
+	}
+	for name, function := range osFunctions() {
+		machine.Builtins[name] = function.builtin
+		machine.arities[name] = function.arity
+	}
+	machine.screen.color = true
+
+	pc, ok := program.Functions[function]
+	if !ok {
+		return nil, fmt.Errorf("function `%s` is not defined", function)
+	}
+
+	machine.RAM[SP] = StackBase
+	if err := machine.call(function, pc, 0, -1, -1); err != nil {
+		return nil, err
+	}
+
+	return machine, nil
+}
+
+// Run executes the program until it halts. If maxSteps is positive and the
+// program is still running after that many steps, Run returns ErrStepLimit.
+func (machine *Machine) Run(maxSteps int) error {
+	for steps := 0; !machine.Halted; steps++ {
+		if maxSteps > 0 && steps >= maxSteps {
+			return ErrStepLimit
+		}
+
+		if err := machine.Step(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Step executes a single instruction. Calls to builtins complete within the
+// step.
+func (machine *Machine) Step() error {
+	if machine.Halted {
+		return nil
+	}
+
+	if machine.PC < 0 || machine.PC >= len(machine.Program.Instructions) {
+		return machine.fail("program counter out of range: %d", machine.PC)
+	}
+
+	instruction := machine.Program.Instructions[machine.PC]
+	machine.Steps++
+
+	var err error
+	next := machine.PC + 1
+
+	switch instruction.Command {
+	case "push":
+		var value int16
+		if instruction.Segment == "constant" {
+			value = int16(instruction.Arg)
+		} else {
+			var address int
+			address, err = machine.address(instruction)
+			if err == nil {
+				machine.checkAccess(instruction, address)
+				value = machine.RAM[address]
+			}
+		}
+		if err == nil {
+			err = machine.push(value)
+		}
+	case "pop":
+		var address int
+		address, err = machine.address(instruction)
+		if err == nil {
+			machine.checkAccess(instruction, address)
+
+			var value int16
+			value, err = machine.pop()
+			machine.RAM[address] = value
+		}
+	case "add", "sub", "eq", "gt", "lt", "and", "or":
+		var x, y int16
+		if y, err = machine.pop(); err != nil {
+			break
+		}
+		if x, err = machine.pop(); err != nil {
+			break
+		}
+		err = machine.push(binaryOperation(instruction.Command, x, y))
+	case "neg", "not":
+		var x int16
+		if x, err = machine.pop(); err != nil {
+			break
+		}
+		if instruction.Command == "neg" {
+			x = -x
+		} else {
+			x = ^x
+		}
+		err = machine.push(x)
+	case "label":
+	case "goto":
+		next = instruction.target
+	case "if-goto":
+		var condition int16
+		condition, err = machine.pop()
+		if condition != 0 {
+			next = instruction.target
+		}
+	case "function":
+		for i := 0; i < instruction.Arg && err == nil; i++ {
+			err = machine.push(0)
+		}
+	case "call":
+		if instruction.target >= 0 {
+			err = machine.call(instruction.Label, instruction.target, instruction.Arg, machine.PC, next)
+			next = machine.PC
+		} else {
+			err = machine.callBuiltin(instruction.Label, instruction.Arg)
+		}
+	case "return":
+		next, err = machine.ret()
+	}
+
+	if err != nil {
+		if _, ok := err.(*RuntimeError); !ok {
+			err = &RuntimeError{PC: machine.PC, Message: err.Error()}
+		}
+		return err
+	}
+
+	if !machine.Halted {
+		machine.PC = next
+	}
+
+	return nil
+}
+
+func binaryOperation(command string, x, y int16) int16 {
+	switch command {
+	case "add":
+		return x + y
+	case "sub":
+		return x - y
+	case "and":
+		return x & y
+	case "or":
+		return x | y
+	case "eq":
+		return boolToInt(x == y)
+	case "gt":
+		return boolToInt(x > y)
+	default:
+		return boolToInt(x < y)
+	}
+}
+
+func boolToInt(b bool) int16 {
+	if b {
+		return -1
+	}
+
+	return 0
+}
+
+func (machine *Machine) address(instruction *Instruction) (int, error) {
+	var address int
+
+	switch instruction.Segment {
+	case "local":
+		address = int(machine.RAM[LCL]) + instruction.Arg
+	case "argument":
+		address = int(machine.RAM[ARG]) + instruction.Arg
+	case "this":
+		address = int(machine.RAM[THIS]) + instruction.Arg
+	case "that":
+		address = int(machine.RAM[THAT]) + instruction.Arg
+	case "pointer":
+		if instruction.Arg > 1 {
+			return 0, fmt.Errorf("pointer index out of range: %d", instruction.Arg)
+		}
+		address = THIS + instruction.Arg
+	case "temp":
+		if instruction.Arg > 7 {
+			return 0, fmt.Errorf("temp index out of range: %d", instruction.Arg)
+		}
+		address = TempBase + instruction.Arg
+	case "static":
+		address = machine.Program.StaticBase[instruction.File] + instruction.Arg
+	}
+
+	if address < 0 || address >= RAMSize {
+		return 0, fmt.Errorf("%s %d: address out of range: %d", instruction.Segment, instruction.Arg, address)
+	}
+
+	return address, nil
+}
+
+// checkAccess passes accesses through `this` and `that` to the heap check.
+func (machine *Machine) checkAccess(instruction *Instruction, address int) {
+	if machine.heapCheck != nil && (instruction.Segment == "this" || instruction.Segment == "that") {
+		machine.heapCheck.access(machine, address)
+	}
+}
+
+func (machine *Machine) push(value int16) error {
+	sp := int(machine.RAM[SP])
+	if sp < StackBase || sp >= HeapBase {
+		return fmt.Errorf("stack overflow")
+	}
+
+	machine.RAM[sp] = value
+	machine.RAM[SP]++
+
+	return nil
+}
+
+func (machine *Machine) pop() (int16, error) {
+	sp := int(machine.RAM[SP])
+	if sp <= StackBase || sp > HeapBase {
+		return 0, fmt.Errorf("stack underflow")
+	}
+
+	machine.RAM[SP]--
+
+	return machine.RAM[sp-1], nil
+}
+
+// call saves the caller's frame and jumps to the function at pc, following
+// the standard VM calling convention.
+func (machine *Machine) call(function string, pc, argCount, callPC, returnPC int) error {
+	for _, value := range []int16{int16(returnPC), machine.RAM[LCL], machine.RAM[ARG], machine.RAM[THIS], machine.RAM[THAT]} {
+		if err := machine.push(value); err != nil {
+			return err
+		}
+	}
+
+	sp := int(machine.RAM[SP])
+	machine.RAM[ARG] = int16(sp - argCount - 5)
+	machine.RAM[LCL] = int16(sp)
+
+	machine.Frames = append(machine.Frames, &Frame{
+		Function: function,
+		CallPC:   callPC,
+		ReturnPC: returnPC,
+		LCL:      sp,
+		ARG:      sp - argCount - 5,
+	})
+	machine.PC = pc
+
+	return nil
+}
+
+func (machine *Machine) ret() (int, error) {
+	if len(machine.Frames) == 0 {
+		return 0, fmt.Errorf("return outside of a function")
+	}
+
+	frame := machine.Frames[len(machine.Frames)-1]
+	machine.Frames = machine.Frames[:len(machine.Frames)-1]
+
+	value, err := machine.pop()
+	if err != nil {
+		return 0, err
+	}
+
+	lcl := int(machine.RAM[LCL])
+	arg := int(machine.RAM[ARG])
+	if lcl-4 < 0 || lcl > RAMSize {
+		return 0, machine.fail("return: LCL out of range: %d", lcl)
+	}
+	if arg < 0 || arg >= RAMSize {
+		return 0, machine.fail("return: ARG out of range: %d", arg)
+	}
+
+	machine.RAM[arg] = value
+	machine.RAM[SP] = int16(arg + 1)
+	machine.RAM[THAT] = machine.RAM[lcl-1]
+	machine.RAM[THIS] = machine.RAM[lcl-2]
+	machine.RAM[ARG] = machine.RAM[lcl-3]
+	machine.RAM[LCL] = machine.RAM[lcl-4]
+
+	if frame.ReturnPC < 0 {
+		machine.Halted = true
+	}
+
+	return frame.ReturnPC, nil
+}
+
+func (machine *Machine) callBuiltin(function string, argCount int) error {
+	builtin, ok := machine.Builtins[function]
+	if !ok {
+		return fmt.Errorf("function `%s` is not defined", function)
+	}
+	if arity, ok := machine.arities[function]; ok && argCount != arity {
+		return machine.fail("%s: expecting %d arguments, got %d", function, arity, argCount)
+	}
+
+	sp := int(machine.RAM[SP])
+	if sp-argCount < StackBase || sp > HeapBase {
+		return fmt.Errorf("stack underflow")
+	}
+
+	args := make([]int16, argCount)
+	copy(args, machine.RAM[sp-argCount:sp])
+	machine.RAM[SP] = int16(sp - argCount)
+
+	value, err := builtin(machine, args)
+	if err != nil {
+		return err
+	}
+
+	return machine.push(value)
+}
+
+func (machine *Machine) fail(format string, args ...interface{}) error {
+	return &RuntimeError{PC: machine.PC, Message: fmt.Sprintf(format, args...)}
+}
+
+// Instruction returns the instruction at the program counter.
+func (machine *Machine) Instruction() *Instruction {
+	if machine.PC < 0 || machine.PC >= len(machine.Program.Instructions) {
+		return nil
+	}
+
+	return machine.Program.Instructions[machine.PC]
+}
@@ -0,0 +1,181 @@
+package vm
+
+import (
+	"bytes"
+	"testing"
+)
+
+func run(t *testing.T, files ...File) *Machine {
+	program, err := NewProgram(files)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	machine, err := New(program)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := machine.Run(100000); err != nil {
+		t.Fatal(err)
+	}
+
+	return machine
+}
+
+func TestRunArithmetic(t *testing.T) {
+	machine := run(t, File{"Main.vm", `
+function Main.main 0
+push constant 7
+push constant 9
+sub
+pop static 0
+push constant 3
+neg
+push constant 2
+lt
+pop static 1
+push constant 5
+push constant 6
+and
+not
+pop static 2
+push constant 0
+return
+`})
+
+	base := machine.Program.StaticBase[0]
+	expected := []int16{-2, -1, ^int16(4)}
+
+	for i, value := range expected {
+		if machine.RAM[base+i] != value {
+			t.Errorf("static %d = %d, want %d", i, machine.RAM[base+i], value)
+		}
+	}
+}
+
+func TestRunCallAndLoop(t *testing.T) {
+	machine := run(t, File{"Main.vm", `
+function Main.main 0
+push constant 5
+call Main.sum 1
+pop static 0
+push constant 0
+return
+// returns 1 + 2 + ... + n
+function Main.sum 1
+label LOOP
+push argument 0
+push constant 0
+eq
+if-goto END
+push local 0
+push argument 0
+add
+pop local 0
+push argument 0
+push constant 1
+sub
+pop argument 0
+goto LOOP
+label END
+push local 0
+return
+`})
+
+	if !machine.Halted {
+		t.Error("expect machine to halt")
+	}
+
+	if value := machine.RAM[machine.Program.StaticBase[0]]; value != 15 {
+		t.Errorf("Main.sum(5) = %d, want 15", value)
+	}
+
+	if machine.RAM[SP] != StackBase+1 {
+		t.Errorf("SP = %d, want %d", machine.RAM[SP], StackBase+1)
+	}
+}
+
+func TestStaticsArePerFile(t *testing.T) {
+	machine := run(t,
+		File{"Main.vm", "function Main.main 0\npush constant 1\npop static 1\ncall Foo.set 0\nreturn"},
+		File{"Foo.vm", "function Foo.set 0\npush constant 2\npop static 0\npush constant 0\nreturn"},
+	)
+
+	if base := machine.Program.StaticBase; base[0] != 16 || base[1] != 18 {
+		t.Errorf("StaticBase = %v, want [16 18]", base)
+	}
+
+	if machine.RAM[17] != 1 || machine.RAM[18] != 2 {
+		t.Errorf("RAM[17..18] = %v, want [1 2]", machine.RAM[17:19])
+	}
+}
+
+func TestRunBuiltin(t *testing.T) {
+	program, _ := NewProgram([]File{{"Main.vm", `
+function Main.main 0
+push constant 6
+push constant 7
+call Math.multiply 2
+call Output.printInt 1
+pop temp 0
+push constant 0
+return
+`}})
+
+	machine, _ := New(program)
+	output := &bytes.Buffer{}
+	machine.Output = output
+
+	if err := machine.Run(0); err != nil {
+		t.Fatal(err)
+	}
+
+	if output.String() != "42" {
+		t.Errorf("output `%s`, want `42`", output.String())
+	}
+}
+
+func TestRunErrors(t *testing.T) {
+	tests := map[string]string{
+		"function Main.main 0\ncall Foo.bar 0\nreturn":                                       "function `Foo.bar` is not defined",
+		"function Main.main 0\npush temp 8\nreturn":                                          "temp index out of range: 8",
+		"function Main.main 0\ncall Main.main 0\nreturn":                                     "stack overflow",
+		"function Main.main 0\npush constant 1\npush constant 0\ncall Math.divide 2\nreturn": "Math.divide: division by zero (Sys.error 3)",
+		"function Main.main 0\ncall Main.f 0\nreturn\nfunction Main.f 0\npush constant 1\npush constant 0\ncall Memory.poke 2\npop temp 0\npush constant 0\nreturn":     "return: LCL out of range: 0",
+		"function Main.main 0\ncall Main.f 0\nreturn\nfunction Main.f 0\npush constant 2\npush constant 30000\ncall Memory.poke 2\npop temp 0\npush constant 0\nreturn": "return: ARG out of range: 30000",
+		"function Main.main 0\npush constant 0\npop pointer 1\npush constant 30000\npop that 0\ncall Math.abs 1\nreturn":                                                "stack underflow",
+		"function Main.main 0\ncall Math.abs 0\nreturn": "Math.abs: expecting 1 arguments, got 0",
+	}
+
+	for code, message := range tests {
+		program, err := NewProgram([]File{{"Main.vm", code}})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		machine, _ := New(program)
+		err = machine.Run(100000)
+
+		if _, ok := err.(*RuntimeError); !ok || err.Error() != message {
+			t.Errorf("expect error `%s`, got %v", message, err)
+		}
+	}
+}
+
+func TestNewProgramErrors(t *testing.T) {
+	tests := map[string]string{
+		"function Main.main 0\npush nowhere 0":         "Main.vm:2: `push` takes a segment and an index",
+		"function Main.main 0\ngoto END":               "Main.vm: label `END` is not defined in `Main.main`",
+		"function Main.main 0\nfunction Main.main 0":   "Main.vm:2: function `Main.main` is already defined",
+		"function Main.main 0\npop constant 0\nreturn": "Main.vm:2: cannot pop to constant",
+		"function Main.main 0\nfrobnicate":             "Main.vm:2: unknown command `frobnicate`",
+	}
+
+	for code, message := range tests {
+		_, err := NewProgram([]File{{"Main.vm", code}})
+		if err == nil || err.Error() != message {
+			t.Errorf("expect error `%s`, got %v", message, err)
+		}
+	}
+}
@@ -0,0 +1,690 @@
+package vm
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// Character codes of the Hack character set that differ from ASCII.
+const (
+	NewLine   = 128
+	BackSpace = 129
+)
+
+// osError is raised by the OS functions on invalid arguments, with the error
+// codes of the nand2tetris OS.
+func osError(code int, function, message string) error {
+	return fmt.Errorf("%s: %s (Sys.error %d)", function, message, code)
+}
+
+// osFunction is a builtin with the number of arguments it takes.
+type osFunction struct {
+	arity   int
+	builtin Builtin
+}
+
+func osFunctions() map[string]osFunction {
+	noop := func(machine *Machine, args []int16) (int16, error) { return 0, nil }
+
+	return map[string]osFunction{
+		"Math.init":     {0, noop},
+		"Math.abs":      {1, mathAbs},
+		"Math.multiply": {2, mathMultiply},
+		"Math.divide":   {2, mathDivide},
+		"Math.min":      {2, mathMin},
+		"Math.max":      {2, mathMax},
+		"Math.sqrt":     {1, mathSqrt},
+
+		"Memory.init":    {0, noop},
+		"Memory.peek":    {1, memoryPeek},
+		"Memory.poke":    {2, memoryPoke},
+		"Memory.alloc":   {1, memoryAlloc},
+		"Memory.deAlloc": {1, memoryDeAlloc},
+
+		"Array.new":     {1, arrayNew},
+		"Array.dispose": {1, memoryDeAlloc},
+
+		"String.new":           {1, stringNew},
+		"String.dispose":       {1, memoryDeAlloc},
+		"String.length":        {1, stringLength},
+		"String.charAt":        {2, stringCharAt},
+		"String.setCharAt":     {3, stringSetCharAt},
+		"String.appendChar":    {2, stringAppendChar},
+		"String.eraseLastChar": {1, stringEraseLastChar},
+		"String.intValue":      {1, stringIntValue},
+		"String.setInt":        {2, stringSetInt},
+		"String.backSpace":     {0, func(machine *Machine, args []int16) (int16, error) { return BackSpace, nil }},
+		"String.doubleQuote":   {0, func(machine *Machine, args []int16) (int16, error) { return '"', nil }},
+		"String.newLine":       {0, func(machine *Machine, args []int16) (int16, error) { return NewLine, nil }},
+
+		"Output.init":        {0, noop},
+		"Output.moveCursor":  {2, outputMoveCursor},
+		"Output.printChar":   {1, outputPrintChar},
+		"Output.printString": {1, outputPrintString},
+		"Output.printInt":    {1, outputPrintInt},
+		"Output.println":     {0, outputPrintln},
+		"Output.backSpace":   {0, outputBackSpace},
+
+		"Screen.init":          {0, noop},
+		"Screen.clearScreen":   {0, screenClearScreen},
+		"Screen.setColor":      {1, screenSetColor},
+		"Screen.drawPixel":     {2, screenDrawPixel},
+		"Screen.drawLine":      {4, screenDrawLine},
+		"Screen.drawRectangle": {4, screenDrawRectangle},
+		"Screen.drawCircle":    {3, screenDrawCircle},
+
+		"Keyboard.init":       {0, noop},
+		"Keyboard.keyPressed": {0, keyboardKeyPressed},
+		"Keyboard.readChar":   {0, keyboardReadChar},
+		"Keyboard.readLine":   {1, keyboardReadLine},
+		"Keyboard.readInt":    {1, keyboardReadInt},
+
+		"Sys.halt":  {0, sysHalt},
+		"Sys.error": {1, sysError},
+		"Sys.wait":  {1, sysWait},
+
+		"Runtime.checkIndex":  {2, runtimeCheckIndex},
+		"Runtime.checkObject": {1, runtimeCheckObject},
+		"Runtime.mod":         {2, runtimeMod},
+		"Runtime.shiftLeft":   {2, runtimeShiftLeft},
+		"Runtime.shiftRight":  {2, runtimeShiftRight},
+		"Runtime.xor":         {2, runtimeXor},
+	}
+}
+
+func mathAbs(machine *Machine, args []int16) (int16, error) {
+	if args[0] < 0 {
+		return -args[0], nil
+	}
+
+	return args[0], nil
+}
+
+func mathMultiply(machine *Machine, args []int16) (int16, error) {
+	return args[0] * args[1], nil
+}
+
+func mathDivide(machine *Machine, args []int16) (int16, error) {
+	if args[1] == 0 {
+		return 0, osError(3, "Math.divide", "division by zero")
+	}
+
+	return int16(int(args[0]) / int(args[1])), nil
+}
+
+func mathMin(machine *Machine, args []int16) (int16, error) {
+	if args[0] < args[1] {
+		return args[0], nil
+	}
+
+	return args[1], nil
+}
+
+func mathMax(machine *Machine, args []int16) (int16, error) {
+	if args[0] > args[1] {
+		return args[0], nil
+	}
+
+	return args[1], nil
+}
+
+func mathSqrt(machine *Machine, args []int16) (int16, error) {
+	if args[0] < 0 {
+		return 0, osError(4, "Math.sqrt", "cannot compute square root of a negative number")
+	}
+
+	y := 0
+	for (y+1)*(y+1) <= int(args[0]) {
+		y++
+	}
+
+	return int16(y), nil
+}
+
+// load reads RAM, failing on addresses outside of it.
+func (machine *Machine) load(address int) (int16, error) {
+	if address < 0 || address >= RAMSize {
+		return 0, fmt.Errorf("address out of range: %d", address)
+	}
+
+	return machine.RAM[address], nil
+}
+
+// store writes RAM, failing on addresses outside of it.
+func (machine *Machine) store(address int, value int16) error {
+	if address < 0 || address >= RAMSize {
+		return fmt.Errorf("address out of range: %d", address)
+	}
+
+	machine.RAM[address] = value
+	return nil
+}
+
+func memoryPeek(machine *Machine, args []int16) (int16, error) {
+	return machine.load(int(args[0]))
+}
+
+func memoryPoke(machine *Machine, args []int16) (int16, error) {
+	return 0, machine.store(int(args[0]), args[1])
+}
+
+func memoryAlloc(machine *Machine, args []int16) (int16, error) {
+	return machine.alloc("Memory.alloc", int(args[0]))
+}
+
+// alloc allocates a heap block. Constructors of classes without fields
+// allocate 0 words, which get a 1-word block so that objects stay distinct.
+func (machine *Machine) alloc(function string, size int) (int16, error) {
+	if size < 0 {
+		return 0, osError(5, function, "allocated memory size must be positive")
+	}
+
+	if size == 0 {
+		size = 1
+	}
+
+	base, ok := machine.heap.alloc(size)
+	if !ok {
+		return 0, osError(6, function, "heap overflow")
+	}
+
+	if machine.heapCheck != nil {
+		machine.heapCheck.alloc(machine, base, size)
+	}
+
+	return int16(base), nil
+}
+
+func memoryDeAlloc(machine *Machine, args []int16) (int16, error) {
+	if machine.heapCheck != nil {
+		machine.heapCheck.deAlloc(machine, int(args[0]))
+	}
+
+	machine.heap.deAlloc(int(args[0]))
+
+	return 0, nil
+}
+
+func arrayNew(machine *Machine, args []int16) (int16, error) {
+	if args[0] <= 0 {
+		return 0, osError(2, "Array.new", "array size must be positive")
+	}
+
+	return machine.alloc("Array.new", int(args[0]))
+}
+
+// Strings are laid out in the heap as [maxLength, length, chars...].
+
+func stringNew(machine *Machine, args []int16) (int16, error) {
+	if args[0] < 0 {
+		return 0, osError(14, "String.new", "maximum length must be non-negative")
+	}
+
+	str, err := machine.alloc("String.new", int(args[0])+2)
+	if err != nil {
+		return 0, err
+	}
+
+	machine.RAM[str] = args[0]
+	machine.RAM[str+1] = 0
+
+	return str, nil
+}
+
+func (machine *Machine) stringHeader(str int16) (int, int, error) {
+	maxLength, err := machine.load(int(str))
+	if err != nil {
+		return 0, 0, err
+	}
+
+	length, err := machine.load(int(str) + 1)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	if length < 0 || length > maxLength || int(str)+2+int(maxLength) > RAMSize {
+		return 0, 0, fmt.Errorf("%d is not a string", str)
+	}
+
+	return int(maxLength), int(length), nil
+}
+
+// ReadString returns the contents of the String object at str.
+func (machine *Machine) ReadString(str int16) (string, error) {
+	_, length, err := machine.stringHeader(str)
+	if err != nil {
+		return "", err
+	}
+
+	result := ""
+	for i := 0; i < length; i++ {
+		result += hackCharToString(machine.RAM[int(str)+2+i])
+	}
+
+	return result, nil
+}
+
+// NewString allocates a String object holding s.
+func (machine *Machine) NewString(s string) (int16, error) {
+	str, err := stringNew(machine, []int16{int16(len(s))})
+	if err != nil {
+		return 0, err
+	}
+
+	for i := 0; i < len(s); i++ {
+		machine.RAM[int(str)+2+i] = int16(s[i])
+	}
+	machine.RAM[str+1] = int16(len(s))
+
+	return str, nil
+}
+
+func stringLength(machine *Machine, args []int16) (int16, error) {
+	_, length, err := machine.stringHeader(args[0])
+
+	return int16(length), err
+}
+
+func stringCharAt(machine *Machine, args []int16) (int16, error) {
+	_, length, err := machine.stringHeader(args[0])
+	if err != nil {
+		return 0, err
+	}
+
+	if args[1] < 0 || int(args[1]) >= length {
+		return 0, osError(15, "String.charAt", "string index out of bounds")
+	}
+
+	return machine.RAM[int(args[0])+2+int(args[1])], nil
+}
+
+func stringSetCharAt(machine *Machine, args []int16) (int16, error) {
+	_, length, err := machine.stringHeader(args[0])
+	if err != nil {
+		return 0, err
+	}
+
+	if args[1] < 0 || int(args[1]) >= length {
+		return 0, osError(16, "String.setCharAt", "string index out of bounds")
+	}
+
+	machine.RAM[int(args[0])+2+int(args[1])] = args[2]
+	return 0, nil
+}
+
+func stringAppendChar(machine *Machine, args []int16) (int16, error) {
+	maxLength, length, err := machine.stringHeader(args[0])
+	if err != nil {
+		return 0, err
+	}
+
+	if length == maxLength {
+		return 0, osError(17, "String.appendChar", "string is full")
+	}
+
+	machine.RAM[int(args[0])+2+length] = args[1]
+	machine.RAM[args[0]+1]++
+
+	return args[0], nil
+}
+
+func stringEraseLastChar(machine *Machine, args []int16) (int16, error) {
+	_, length, err := machine.stringHeader(args[0])
+	if err != nil {
+		return 0, err
+	}
+
+	if length == 0 {
+		return 0, osError(18, "String.eraseLastChar", "string is empty")
+	}
+
+	machine.RAM[args[0]+1]--
+	return 0, nil
+}
+
+func stringIntValue(machine *Machine, args []int16) (int16, error) {
+	s, err := machine.ReadString(args[0])
+	if err != nil {
+		return 0, err
+	}
+
+	return parseInt(s), nil
+}
+
+// parseInt parses the integer prefix of s like String.intValue.
+func parseInt(s string) int16 {
+	var value int16
+	negative := len(s) > 0 && s[0] == '-'
+	if negative {
+		s = s[1:]
+	}
+
+	for i := 0; i < len(s) && '0' <= s[i] && s[i] <= '9'; i++ {
+		value = value*10 + int16(s[i]-'0')
+	}
+
+	if negative {
+		return -value
+	}
+
+	return value
+}
+
+func stringSetInt(machine *Machine, args []int16) (int16, error) {
+	maxLength, _, err := machine.stringHeader(args[0])
+	if err != nil {
+		return 0, err
+	}
+
+	s := strconv.Itoa(int(args[1]))
+	if len(s) > maxLength {
+		return 0, osError(19, "String.setInt", "insufficient string capacity")
+	}
+
+	for i := 0; i < len(s); i++ {
+		machine.RAM[int(args[0])+2+i] = int16(s[i])
+	}
+	machine.RAM[args[0]+1] = int16(len(s))
+
+	return 0, nil
+}
+
+func hackCharToString(c int16) string {
+	switch c {
+	case NewLine:
+		return "\n"
+	case BackSpace:
+		return "\b"
+	default:
+		return string(rune(c))
+	}
+}
+
+func (machine *Machine) print(s string) error {
+	_, err := io.WriteString(machine.Output, s)
+
+	return err
+}
+
+func outputMoveCursor(machine *Machine, args []int16) (int16, error) {
+	if args[0] < 0 || args[0] > 22 || args[1] < 0 || args[1] > 63 {
+		return 0, osError(20, "Output.moveCursor", "illegal cursor location")
+	}
+
+	return 0, nil
+}
+
+func outputPrintChar(machine *Machine, args []int16) (int16, error) {
+	return 0, machine.print(hackCharToString(args[0]))
+}
+
+func outputPrintString(machine *Machine, args []int16) (int16, error) {
+	s, err := machine.ReadString(args[0])
+	if err != nil {
+		return 0, err
+	}
+
+	return 0, machine.print(s)
+}
+
+func outputPrintInt(machine *Machine, args []int16) (int16, error) {
+	return 0, machine.print(strconv.Itoa(int(args[0])))
+}
+
+func outputPrintln(machine *Machine, args []int16) (int16, error) {
+	return 0, machine.print("\n")
+}
+
+func outputBackSpace(machine *Machine, args []int16) (int16, error) {
+	return 0, machine.print("\b")
+}
+
+// The screen is 512 x 256 pixels, 16 pixels per word, row by row.
+const (
+	screenWidth  = 512
+	screenHeight = 256
+)
+
+func screenClearScreen(machine *Machine, args []int16) (int16, error) {
+	for address := ScreenBase; address < KeyboardIn; address++ {
+		machine.RAM[address] = 0
+	}
+
+	return 0, nil
+}
+
+func screenSetColor(machine *Machine, args []int16) (int16, error) {
+	machine.screen.color = args[0] != 0
+
+	return 0, nil
+}
+
+func (machine *Machine) drawPixel(x, y int) {
+	if x < 0 || x >= screenWidth || y < 0 || y >= screenHeight {
+		return
+	}
+
+	address := ScreenBase + y*(screenWidth/16) + x/16
+	mask := int16(1) << uint(x%16)
+
+	if machine.screen.color {
+		machine.RAM[address] |= mask
+	} else {
+		machine.RAM[address] &^= mask
+	}
+}
+
+func onScreen(x, y int16) bool {
+	return 0 <= x && int(x) < screenWidth && 0 <= y && int(y) < screenHeight
+}
+
+func screenDrawPixel(machine *Machine, args []int16) (int16, error) {
+	if !onScreen(args[0], args[1]) {
+		return 0, osError(7, "Screen.drawPixel", "illegal pixel coordinates")
+	}
+
+	machine.drawPixel(int(args[0]), int(args[1]))
+	return 0, nil
+}
+
+func screenDrawLine(machine *Machine, args []int16) (int16, error) {
+	if !onScreen(args[0], args[1]) || !onScreen(args[2], args[3]) {
+		return 0, osError(8, "Screen.drawLine", "illegal line coordinates")
+	}
+
+	x1, y1, x2, y2 := int(args[0]), int(args[1]), int(args[2]), int(args[3])
+	dx, dy := abs(x2-x1), abs(y2-y1)
+	sx, sy := sign(x2-x1), sign(y2-y1)
+
+	diff := dx - dy
+	for {
+		machine.drawPixel(x1, y1)
+		if x1 == x2 && y1 == y2 {
+			break
+		}
+
+		if 2*diff > -dy {
+			diff -= dy
+			x1 += sx
+		}
+		if 2*diff < dx {
+			diff += dx
+			y1 += sy
+		}
+	}
+
+	return 0, nil
+}
+
+func screenDrawRectangle(machine *Machine, args []int16) (int16, error) {
+	if !onScreen(args[0], args[1]) || !onScreen(args[2], args[3]) || args[0] > args[2] || args[1] > args[3] {
+		return 0, osError(9, "Screen.drawRectangle", "illegal rectangle coordinates")
+	}
+
+	for y := int(args[1]); y <= int(args[3]); y++ {
+		for x := int(args[0]); x <= int(args[2]); x++ {
+			machine.drawPixel(x, y)
+		}
+	}
+
+	return 0, nil
+}
+
+func screenDrawCircle(machine *Machine, args []int16) (int16, error) {
+	if !onScreen(args[0], args[1]) {
+		return 0, osError(12, "Screen.drawCircle", "illegal center coordinates")
+	}
+
+	if args[2] < 0 || args[2] > 181 {
+		return 0, osError(13, "Screen.drawCircle", "illegal radius")
+	}
+
+	cx, cy, r := int(args[0]), int(args[1]), int(args[2])
+	for dy := -r; dy <= r; dy++ {
+		dx := 0
+		for (dx+1)*(dx+1) <= r*r-dy*dy {
+			dx++
+		}
+
+		for x := cx - dx; x <= cx+dx; x++ {
+			machine.drawPixel(x, cy+dy)
+		}
+	}
+
+	return 0, nil
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+
+	return x
+}
+
+func sign(x int) int {
+	switch {
+	case x > 0:
+		return 1
+	case x < 0:
+		return -1
+	default:
+		return 0
+	}
+}
+
+func keyboardKeyPressed(machine *Machine, args []int16) (int16, error) {
+	var key int16
+	if len(machine.Keyboard) > 0 {
+		key = machine.Keyboard[0]
+		machine.Keyboard = machine.Keyboard[1:]
+	}
+	machine.RAM[KeyboardIn] = key
+
+	return key, nil
+}
+
+// readKey returns the next key of the script, skipping polls where no key
+// is pressed.
+func (machine *Machine) readKey(function string) (int16, error) {
+	for len(machine.Keyboard) > 0 {
+		key := machine.Keyboard[0]
+		machine.Keyboard = machine.Keyboard[1:]
+
+		if key != 0 {
+			return key, nil
+		}
+	}
+
+	return 0, fmt.Errorf("%s: no keyboard input", function)
+}
+
+func keyboardReadChar(machine *Machine, args []int16) (int16, error) {
+	key, err := machine.readKey("Keyboard.readChar")
+	if err != nil {
+		return 0, err
+	}
+
+	return key, machine.print(hackCharToString(key))
+}
+
+func (machine *Machine) readLine(function string, message int16) (string, error) {
+	if _, err := outputPrintString(machine, []int16{message}); err != nil {
+		return "", err
+	}
+
+	line := ""
+	for {
+		key, err := machine.readKey(function)
+		if err != nil {
+			return "", err
+		}
+
+		switch key {
+		case NewLine:
+			return line, machine.print("\n")
+		case BackSpace:
+			if len(line) > 0 {
+				line = line[:len(line)-1]
+			}
+		default:
+			line += string(rune(key))
+		}
+
+		if err := machine.print(hackCharToString(key)); err != nil {
+			return "", err
+		}
+	}
+}
+
+func keyboardReadLine(machine *Machine, args []int16) (int16, error) {
+	line, err := machine.readLine("Keyboard.readLine", args[0])
+	if err != nil {
+		return 0, err
+	}
+
+	return machine.NewString(line)
+}
+
+func keyboardReadInt(machine *Machine, args []int16) (int16, error) {
+	line, err := machine.readLine("Keyboard.readInt", args[0])
+	if err != nil {
+		return 0, err
+	}
+
+	return parseInt(line), nil
+}
+
+func sysHalt(machine *Machine, args []int16) (int16, error) {
+	machine.Halted = true
+
+	return 0, nil
+}
+
+func sysError(machine *Machine, args []int16) (int16, error) {
+	return 0, fmt.Errorf("Sys.error: ERR%d", args[0])
+}
+
+func sysWait(machine *Machine, args []int16) (int16, error) {
+	if args[0] < 0 {
+		return 0, osError(1, "Sys.wait", "duration must be positive")
+	}
+
+	return 0, nil
+}
+
+// KeyboardInput converts text to scripted keyboard input, mapping newlines
+// to the Hack newline key.
+func KeyboardInput(text string) []int16 {
+	keys := []int16{}
+
+	for _, c := range text {
+		if c == '\n' {
+			c = NewLine
+		}
+		keys = append(keys, int16(c))
+	}
+
+	return keys
+}
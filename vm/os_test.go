@@ -0,0 +1,203 @@
+package vm
+
+import (
+	"bytes"
+	"testing"
+)
+
+func newMachine(t *testing.T) *Machine {
+	program, err := NewProgram([]File{{"Main.vm", "function Main.main 0\npush constant 0\nreturn"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	machine, err := New(program)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return machine
+}
+
+func call(t *testing.T, machine *Machine, function string, args ...int16) int16 {
+	value, err := machine.Builtins[function](machine, args)
+	if err != nil {
+		t.Fatalf("%s%v: %v", function, args, err)
+	}
+
+	return value
+}
+
+func TestMath(t *testing.T) {
+	machine := newMachine(t)
+
+	tests := []struct {
+		function string
+		args     []int16
+		expected int16
+	}{
+		{"Math.multiply", []int16{-7, 6}, -42},
+		{"Math.multiply", []int16{300, 300}, 24464},
+		{"Math.divide", []int16{-7, 2}, -3},
+		{"Math.sqrt", []int16{32767}, 181},
+		{"Math.abs", []int16{-5}, 5},
+		{"Math.min", []int16{-5, 3}, -5},
+		{"Math.max", []int16{-5, 3}, 3},
+	}
+
+	for _, test := range tests {
+		if value := call(t, machine, test.function, test.args...); value != test.expected {
+			t.Errorf("%s%v = %d, want %d", test.function, test.args, value, test.expected)
+		}
+	}
+}
+
+func TestRuntimeOperators(t *testing.T) {
+	machine := newMachine(t)
+
+	tests := []struct {
+		function string
+		args     []int16
+		expected int16
+	}{
+		{"Runtime.mod", []int16{7, 3}, 1},
+		{"Runtime.mod", []int16{-7, 3}, -1},
+		{"Runtime.mod", []int16{7, -3}, 1},
+		{"Runtime.shiftLeft", []int16{3, 2}, 12},
+		{"Runtime.shiftLeft", []int16{-3, 2}, -12},
+		{"Runtime.shiftLeft", []int16{1, 15}, -32768},
+		{"Runtime.shiftLeft", []int16{1, 16}, 0},
+		{"Runtime.shiftRight", []int16{12, 2}, 3},
+		{"Runtime.shiftRight", []int16{-7, 1}, -4},
+		{"Runtime.shiftRight", []int16{-1, 20}, -1},
+		{"Runtime.xor", []int16{12, 10}, 6},
+		{"Runtime.xor", []int16{-1, 5}, -6},
+	}
+
+	for _, test := range tests {
+		if value := call(t, machine, test.function, test.args...); value != test.expected {
+			t.Errorf("%s%v = %d, want %d", test.function, test.args, value, test.expected)
+		}
+	}
+
+	for _, test := range []struct {
+		function string
+		args     []int16
+		message  string
+	}{
+		{"Runtime.mod", []int16{1, 0}, "Sys.error: ERR22"},
+		{"Runtime.shiftRight", []int16{1, -1}, "Sys.error: ERR23"},
+	} {
+		if _, err := machine.Builtins[test.function](machine, test.args); err == nil || err.Error() != test.message {
+			t.Errorf("%s%v: expect error `%s`, got %v", test.function, test.args, test.message, err)
+		}
+	}
+}
+
+func TestMemoryAllocReusesFreedBlocks(t *testing.T) {
+	machine := newMachine(t)
+
+	a := call(t, machine, "Memory.alloc", 3)
+	b := call(t, machine, "Memory.alloc", 2)
+	if a != HeapBase || b != HeapBase+3 {
+		t.Errorf("alloc returns %d, %d, want %d, %d", a, b, HeapBase, HeapBase+3)
+	}
+
+	call(t, machine, "Memory.deAlloc", a)
+	call(t, machine, "Memory.deAlloc", b)
+
+	if c := call(t, machine, "Memory.alloc", 5); c != HeapBase {
+		t.Errorf("alloc after deAlloc returns %d, want %d", c, HeapBase)
+	}
+
+	if _, err := machine.Builtins["Memory.alloc"](machine, []int16{16000}); err == nil {
+		t.Error("expect heap overflow")
+	}
+}
+
+func TestString(t *testing.T) {
+	machine := newMachine(t)
+
+	str := call(t, machine, "String.new", 6)
+	for _, c := range "-12a" {
+		call(t, machine, "String.appendChar", str, int16(c))
+	}
+
+	if length := call(t, machine, "String.length", str); length != 4 {
+		t.Errorf("length = %d, want 4", length)
+	}
+
+	if value := call(t, machine, "String.intValue", str); value != -12 {
+		t.Errorf("intValue = %d, want -12", value)
+	}
+
+	call(t, machine, "String.eraseLastChar", str)
+	call(t, machine, "String.setCharAt", str, 0, '+')
+
+	if s, _ := machine.ReadString(str); s != "+12" {
+		t.Errorf("string is `%s`, want `+12`", s)
+	}
+
+	call(t, machine, "String.setInt", str, -3210)
+	if s, _ := machine.ReadString(str); s != "-3210" {
+		t.Errorf("string is `%s`, want `-3210`", s)
+	}
+
+	if _, err := machine.Builtins["String.charAt"](machine, []int16{str, 5}); err == nil {
+		t.Error("expect charAt out of bounds to fail")
+	}
+}
+
+func TestKeyboard(t *testing.T) {
+	machine := newMachine(t)
+	output := &bytes.Buffer{}
+	machine.Output = output
+	machine.Keyboard = append([]int16{0, 'x'}, KeyboardInput("-42\nabc\n")...)
+
+	if key := call(t, machine, "Keyboard.keyPressed"); key != 0 {
+		t.Errorf("keyPressed = %d, want 0", key)
+	}
+
+	if key := call(t, machine, "Keyboard.readChar"); key != 'x' {
+		t.Errorf("readChar = %d, want %d", key, 'x')
+	}
+
+	message, _ := machine.NewString("n? ")
+	if value := call(t, machine, "Keyboard.readInt", message); value != -42 {
+		t.Errorf("readInt = %d, want -42", value)
+	}
+
+	line := call(t, machine, "Keyboard.readLine", message)
+	if s, _ := machine.ReadString(line); s != "abc" {
+		t.Errorf("readLine = `%s`, want `abc`", s)
+	}
+
+	if output.String() != "xn? -42\nn? abc\n" {
+		t.Errorf("output `%q`", output.String())
+	}
+
+	if _, err := machine.Builtins["Keyboard.readChar"](machine, nil); err == nil {
+		t.Error("expect readChar to fail without input")
+	}
+}
+
+func TestScreen(t *testing.T) {
+	machine := newMachine(t)
+
+	call(t, machine, "Screen.drawRectangle", 14, 1, 17, 2)
+	for _, address := range []int{ScreenBase + 32, ScreenBase + 64} {
+		if machine.RAM[address] != -16384 || machine.RAM[address+1] != 3 {
+			t.Errorf("RAM[%d..%d] = %v", address, address+1, machine.RAM[address:address+2])
+		}
+	}
+
+	call(t, machine, "Screen.setColor", 0)
+	call(t, machine, "Screen.drawLine", 0, 1, 511, 1)
+	if machine.RAM[ScreenBase+32] != 0 || machine.RAM[ScreenBase+64] != -16384 {
+		t.Errorf("drawLine should clear row 1")
+	}
+
+	if _, err := machine.Builtins["Screen.drawPixel"](machine, []int16{512, 0}); err == nil {
+		t.Error("expect drawPixel off screen to fail")
+	}
+}
@@ -0,0 +1,193 @@
+package vm
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// File is the VM code of one class, e.g. Main.vm.
+type File struct {
+	Name string
+	Code string
+}
+
+// Instruction is a parsed VM command.
+type Instruction struct {
+	Command string
+	Segment string
+	Arg     int
+	Label   string // label, goto and if-goto target; function and call name
+
+	// File is the index of the file in Program.Files, and Index is the
+	// position of the command within that file.
+	File, Index int
+
+	function string // enclosing function, which scopes labels
+	target   int    // resolved jump target, or the entry of a called function
+}
+
+func (instruction *Instruction) String() string {
+	switch instruction.Command {
+	case "push", "pop":
+		return fmt.Sprintf("%s %s %d", instruction.Command, instruction.Segment, instruction.Arg)
+	case "label", "goto", "if-goto":
+		return instruction.Command + " " + instruction.Label
+	case "function", "call":
+		return fmt.Sprintf("%s %s %d", instruction.Command, instruction.Label, instruction.Arg)
+	default:
+		return instruction.Command
+	}
+}
+
+// Program is a set of VM files loaded into a single instruction memory.
+type Program struct {
+	Files        []string
+	Instructions []*Instruction
+	Functions    map[string]int
+
+	// StaticBase is the address of `static 0` of each file.
+	StaticBase []int
+}
+
+var arithmeticCommands = map[string]bool{
+	"add": true, "sub": true, "neg": true,
+	"eq": true, "gt": true, "lt": true,
+	"and": true, "or": true, "not": true,
+}
+
+var segments = map[string]bool{
+	"constant": true, "local": true, "argument": true, "this": true,
+	"that": true, "pointer": true, "temp": true, "static": true,
+}
+
+// NewProgram parses and links VM files.
+func NewProgram(files []File) (*Program, error) {
+	program := &Program{Functions: map[string]int{}}
+
+	labels := map[string]int{}
+	staticAddress := 16
+
+	for fileIndex, file := range files {
+		program.Files = append(program.Files, file.Name)
+		program.StaticBase = append(program.StaticBase, staticAddress)
+
+		function := ""
+		index := 0
+		for lineNumber, line := range strings.Split(file.Code, "\n") {
+			if i := strings.Index(line, "//"); i >= 0 {
+				line = line[:i]
+			}
+
+			fields := strings.Fields(line)
+			if len(fields) == 0 {
+				continue
+			}
+
+			instruction, err := parseInstruction(fields)
+			if err != nil {
+				return nil, fmt.Errorf("%s:%d: %v", file.Name, lineNumber+1, err)
+			}
+			instruction.File = fileIndex
+			instruction.Index = index
+			instruction.function = function
+			index++
+
+			pc := len(program.Instructions)
+			switch instruction.Command {
+			case "function":
+				function = instruction.Label
+				instruction.function = function
+				if _, ok := program.Functions[function]; ok {
+					return nil, fmt.Errorf("%s:%d: function `%s` is already defined", file.Name, lineNumber+1, function)
+				}
+				program.Functions[function] = pc
+			case "label":
+				labels[function+"$"+instruction.Label] = pc
+			case "push", "pop":
+				if end := program.StaticBase[fileIndex] + instruction.Arg + 1; instruction.Segment == "static" && end > staticAddress {
+					staticAddress = end
+				}
+			}
+
+			program.Instructions = append(program.Instructions, instruction)
+		}
+	}
+
+	if staticAddress > 256 {
+		return nil, fmt.Errorf("too many static variables: %d", staticAddress-16)
+	}
+
+	for _, instruction := range program.Instructions {
+		instruction.target = -1
+
+		switch instruction.Command {
+		case "goto", "if-goto":
+			target, ok := labels[instruction.function+"$"+instruction.Label]
+			if !ok {
+				return nil, fmt.Errorf("%s: label `%s` is not defined in `%s`", program.Files[instruction.File], instruction.Label, instruction.function)
+			}
+			instruction.target = target
+		case "call":
+			if target, ok := program.Functions[instruction.Label]; ok {
+				instruction.target = target
+			}
+		}
+	}
+
+	return program, nil
+}
+
+func parseInstruction(fields []string) (*Instruction, error) {
+	instruction := &Instruction{Command: fields[0]}
+
+	switch {
+	case arithmeticCommands[instruction.Command] || instruction.Command == "return":
+		if len(fields) != 1 {
+			return nil, fmt.Errorf("`%s` takes no arguments", instruction.Command)
+		}
+	case instruction.Command == "label" || instruction.Command == "goto" || instruction.Command == "if-goto":
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("`%s` takes a label", instruction.Command)
+		}
+		instruction.Label = fields[1]
+	case instruction.Command == "push" || instruction.Command == "pop":
+		if len(fields) != 3 || !segments[fields[1]] {
+			return nil, fmt.Errorf("`%s` takes a segment and an index", instruction.Command)
+		}
+		if instruction.Command == "pop" && fields[1] == "constant" {
+			return nil, fmt.Errorf("cannot pop to constant")
+		}
+		instruction.Segment = fields[1]
+
+		arg, err := parseArg(fields[2])
+		if err != nil {
+			return nil, err
+		}
+		instruction.Arg = arg
+	case instruction.Command == "function" || instruction.Command == "call":
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("`%s` takes a name and a count", instruction.Command)
+		}
+		instruction.Label = fields[1]
+
+		arg, err := parseArg(fields[2])
+		if err != nil {
+			return nil, err
+		}
+		instruction.Arg = arg
+	default:
+		return nil, fmt.Errorf("unknown command `%s`", instruction.Command)
+	}
+
+	return instruction, nil
+}
+
+func parseArg(str string) (int, error) {
+	arg, err := strconv.Atoi(str)
+	if err != nil || arg < 0 || arg > 32767 {
+		return 0, fmt.Errorf("invalid argument `%s`", str)
+	}
+
+	return arg, nil
+}
@@ -0,0 +1,70 @@
+package vm
+
+import "fmt"
+
+// The Runtime class implements the checks that the compiler emits in
+// checked mode, and the operators of the language extensions that the VM
+// lacks.
+
+// runtimeCheckIndex returns the address of an array element, failing if the
+// array is null or, when the array is a block allocated on the heap, if the
+// index is out of its bounds.
+func runtimeCheckIndex(machine *Machine, args []int16) (int16, error) {
+	index, base := args[0], args[1]
+
+	if base == 0 {
+		return 0, fmt.Errorf("array is null")
+	}
+
+	if size, ok := machine.heap.blocks[int(base)]; ok && (index < 0 || int(index) >= size) {
+		return 0, fmt.Errorf("index %d is out of bounds for length %d", index, size)
+	}
+
+	return base + index, nil
+}
+
+// runtimeCheckObject returns the object a method is called on, failing if
+// it is null.
+func runtimeCheckObject(machine *Machine, args []int16) (int16, error) {
+	if args[0] == 0 {
+		return 0, fmt.Errorf("method call on null")
+	}
+
+	return args[0], nil
+}
+
+// runtimeMod returns the remainder of x / y, which has the sign of x, as
+// Math.divide truncates toward zero. Like the Jack Runtime class, it fails
+// with Sys.error 22 when y is 0.
+func runtimeMod(machine *Machine, args []int16) (int16, error) {
+	if args[1] == 0 {
+		return sysError(machine, []int16{22})
+	}
+
+	return int16(int(args[0]) % int(args[1])), nil
+}
+
+// runtimeShiftLeft returns x shifted left by n bits, 0 when n is 16 or more.
+// A negative n fails with Sys.error 23.
+func runtimeShiftLeft(machine *Machine, args []int16) (int16, error) {
+	if args[1] < 0 {
+		return sysError(machine, []int16{23})
+	}
+
+	return args[0] << uint(args[1]), nil
+}
+
+// runtimeShiftRight returns x shifted right by n bits, copying the sign bit,
+// so that a negative x stays negative: -7 >> 1 is -4.
+func runtimeShiftRight(machine *Machine, args []int16) (int16, error) {
+	if args[1] < 0 {
+		return sysError(machine, []int16{23})
+	}
+
+	return args[0] >> uint(args[1]), nil
+}
+
+// runtimeXor returns the bitwise exclusive or of x and y.
+func runtimeXor(machine *Machine, args []int16) (int16, error) {
+	return args[0] ^ args[1], nil
+}
@@ -0,0 +1,170 @@
+// Package vmtest compares VM programs by behavior rather than by text: both
+// programs run on the emulator against the same scripted input, and their
+// output, statics, heap and screen must end up the same.
+package vmtest
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/uiureo/jack/vm"
+)
+
+// DefaultMaxSteps bounds a run when the scenario doesn't set MaxSteps.
+const DefaultMaxSteps = 10000000
+
+const (
+	staticBase = 16
+	staticEnd  = vm.StackBase
+)
+
+// Scenario is the input of a run.
+type Scenario struct {
+	Name string
+
+	// Keyboard is the scripted keyboard input, see vm.Machine.Keyboard.
+	// Once it is exhausted, the next Keyboard.keyPressed halts the program,
+	// so interactive programs stop at the same point in both runs.
+	Keyboard []int16
+
+	// RAM is stored before the program starts, by address.
+	RAM map[int]int16
+
+	MaxSteps int
+}
+
+// State is what a run leaves behind.
+type State struct {
+	Output  string
+	Statics []int16
+	Heap    []int16
+	Screen  []int16
+
+	// Err is the message of the runtime error that stopped the program, if
+	// any. It doesn't include the program counter, which depends on the code
+	// layout.
+	Err string
+}
+
+// Run runs files on the emulator under scenario. It returns an error only if
+// the program can't be loaded.
+func Run(files []vm.File, scenario Scenario) (*State, error) {
+	program, err := vm.NewProgram(files)
+	if err != nil {
+		return nil, err
+	}
+
+	machine, err := vm.New(program)
+	if err != nil {
+		return nil, err
+	}
+
+	output := &bytes.Buffer{}
+	machine.Output = output
+	machine.Keyboard = append([]int16{}, scenario.Keyboard...)
+
+	for address, value := range scenario.RAM {
+		machine.RAM[address] = value
+	}
+
+	keyPressed := machine.Builtins["Keyboard.keyPressed"]
+	machine.Builtins["Keyboard.keyPressed"] = func(machine *vm.Machine, args []int16) (int16, error) {
+		if len(machine.Keyboard) == 0 {
+			machine.Halted = true
+			return 0, nil
+		}
+
+		return keyPressed(machine, args)
+	}
+
+	maxSteps := scenario.MaxSteps
+	if maxSteps == 0 {
+		maxSteps = DefaultMaxSteps
+	}
+
+	state := &State{}
+	if err := machine.Run(maxSteps); err != nil {
+		state.Err = err.Error()
+	}
+
+	state.Output = output.String()
+	state.Statics = append([]int16{}, machine.RAM[staticBase:staticEnd]...)
+	state.Heap = append([]int16{}, machine.RAM[vm.HeapBase:vm.ScreenBase]...)
+	state.Screen = append([]int16{}, machine.RAM[vm.ScreenBase:vm.KeyboardIn]...)
+
+	return state, nil
+}
+
+// Diff describes how got differs from want, or returns "" if they are the
+// same.
+func Diff(got, want *State) string {
+	result := ""
+
+	if got.Err != want.Err {
+		result += fmt.Sprintf("error: `%s`, want `%s`\n", got.Err, want.Err)
+	}
+
+	if got.Output != want.Output {
+		result += fmt.Sprintf("output: %q, want %q\n", got.Output, want.Output)
+	}
+
+	result += diffMemory("static", staticBase, got.Statics, want.Statics)
+	result += diffMemory("heap", vm.HeapBase, got.Heap, want.Heap)
+	result += diffMemory("screen", vm.ScreenBase, got.Screen, want.Screen)
+
+	return result
+}
+
+// diffMemory reports the first differing words of a memory region.
+func diffMemory(region string, base int, got, want []int16) string {
+	const limit = 5
+
+	result := ""
+	count := 0
+
+	for i := range want {
+		if got[i] == want[i] {
+			continue
+		}
+
+		if count < limit {
+			result += fmt.Sprintf("%s: RAM[%d] = %d, want %d\n", region, base+i, got[i], want[i])
+		}
+		count++
+	}
+
+	if count > limit {
+		result += fmt.Sprintf("%s: %d more differences\n", region, count-limit)
+	}
+
+	return result
+}
+
+// Compare runs got and want under each scenario, or once without input if no
+// scenario is given, and reports any difference in their final states.
+func Compare(t testing.TB, got, want []vm.File, scenarios ...Scenario) {
+	t.Helper()
+
+	if len(scenarios) == 0 {
+		scenarios = []Scenario{{}}
+	}
+
+	for _, scenario := range scenarios {
+		gotState, err := Run(got, scenario)
+		if err != nil {
+			t.Errorf("%s: %v", scenario.Name, err)
+			continue
+		}
+
+		wantState, err := Run(want, scenario)
+		if err != nil {
+			t.Errorf("%s: reference: %v", scenario.Name, err)
+			continue
+		}
+
+		if diff := Diff(gotState, wantState); diff != "" {
+			t.Errorf("%s: programs behave differently\n%s", scenario.Name, diff)
+		}
+	}
+}
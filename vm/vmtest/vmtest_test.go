@@ -0,0 +1,104 @@
+package vmtest
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/uiureo/jack/vm"
+)
+
+const countdown = `
+function Main.main 1
+push constant 3
+pop local 0
+label LOOP
+push local 0
+if-goto BODY
+goto END
+label BODY
+push local 0
+call Output.printInt 1
+pop temp 0
+push local 0
+push constant 1
+sub
+pop local 0
+goto LOOP
+label END
+push constant 0
+return
+`
+
+func TestRunIgnoresLayout(t *testing.T) {
+	renamed := strings.NewReplacer("LOOP", "WHILE_EXP0", "BODY", "WHILE_BODY0", "END", "WHILE_END0").Replace(countdown)
+
+	a, err := Run([]vm.File{{Name: "Main.vm", Code: countdown}}, Scenario{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := Run([]vm.File{{Name: "Main.vm", Code: renamed}}, Scenario{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if a.Output != "321" {
+		t.Errorf("expect output `321`, got `%s`", a.Output)
+	}
+	if diff := Diff(a, b); diff != "" {
+		t.Errorf("expect no difference, got:\n%s", diff)
+	}
+}
+
+func TestDiff(t *testing.T) {
+	poke := strings.Replace(countdown, "call Output.printInt 1", "pop static 0\npush constant 2048\npush local 0\ncall Memory.poke 2", 1)
+
+	a, _ := Run([]vm.File{{Name: "Main.vm", Code: countdown}}, Scenario{})
+	b, err := Run([]vm.File{{Name: "Main.vm", Code: poke}}, Scenario{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "output: \"\", want \"321\"\nstatic: RAM[16] = 1, want 0\nheap: RAM[2048] = 1, want 0\n"
+	if diff := Diff(b, a); diff != expected {
+		t.Errorf("expect:\n%s\ngot:\n%s", expected, diff)
+	}
+}
+
+func TestRunHaltsWhenKeyboardIsExhausted(t *testing.T) {
+	code := `
+function Main.main 0
+label LOOP
+call Keyboard.keyPressed 0
+pop static 0
+goto LOOP
+`
+
+	state, err := Run([]vm.File{{Name: "Main.vm", Code: code}}, Scenario{Keyboard: []int16{0, 65}, MaxSteps: 1000})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if state.Err != "" || state.Statics[0] != 65 {
+		t.Errorf("expect the program to halt after reading 65, got error `%s` and static 0 = %d", state.Err, state.Statics[0])
+	}
+}
+
+func TestRunPreloadsRAM(t *testing.T) {
+	code := `
+function Main.main 0
+push constant 8000
+call Memory.peek 1
+pop static 0
+push constant 0
+return
+`
+
+	state, err := Run([]vm.File{{Name: "Main.vm", Code: code}}, Scenario{RAM: map[int]int16{8000: 42}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if state.Statics[0] != 42 {
+		t.Errorf("expect static 0 to be 42, got %d", state.Statics[0])
+	}
+}